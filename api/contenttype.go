@@ -0,0 +1,104 @@
+package api
+
+import (
+	"mime"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// webContentTypes covers web asset extensions that minimal systems often
+// lack a mime.types entry for.
+var webContentTypes = map[string]string{
+	".html":        "text/html",
+	".htm":         "text/html",
+	".css":         "text/css",
+	".js":          "text/javascript",
+	".mjs":         "text/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".xml":         "application/xml",
+	".txt":         "text/plain",
+	".md":          "text/markdown",
+	".csv":         "text/csv",
+	".svg":         "image/svg+xml",
+	".png":         "image/png",
+	".jpg":         "image/jpeg",
+	".jpeg":        "image/jpeg",
+	".gif":         "image/gif",
+	".webp":        "image/webp",
+	".avif":        "image/avif",
+	".ico":         "image/x-icon",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".wasm":        "application/wasm",
+	".pdf":         "application/pdf",
+	".mp4":         "video/mp4",
+	".webm":        "video/webm",
+	".mp3":         "audio/mpeg",
+	".zip":         "application/zip",
+}
+
+// ContentTypeRule maps a glob to a Content-Type. A pattern without a slash
+// matches the file name, one with a slash the whole object path.
+type ContentTypeRule struct {
+	Pattern     string
+	ContentType string
+}
+
+func (r ContentTypeRule) matches(objectPath string) bool {
+	return matchPattern(r.Pattern, objectPath)
+}
+
+func matchPattern(pattern, objectPath string) bool {
+	name := objectPath
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(objectPath)
+	}
+	ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), name)
+	return ok
+}
+
+// ContentType is the Content-Type an upload to objectPath is sent with.
+func (s *BCDNStorage) ContentType(objectPath string) string {
+	for _, rule := range s.ContentTypes {
+		if rule.matches(objectPath) {
+			return rule.ContentType
+		}
+	}
+	return DetectContentType(objectPath)
+}
+
+// DetectContentType derives the Content-Type from the extension, using the
+// system MIME table and webContentTypes as a fallback. Text types get an
+// explicit UTF-8 charset so browsers do not have to guess.
+func DetectContentType(objectPath string) string {
+	ext := strings.ToLower(filepath.Ext(objectPath))
+	if ext == "" {
+		return "application/octet-stream"
+	}
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		contentType = webContentTypes[ext]
+	}
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	if isTextType(contentType) && !strings.Contains(contentType, "charset=") {
+		contentType += "; charset=utf-8"
+	}
+	return contentType
+}
+
+func isTextType(contentType string) bool {
+	base, _, _ := strings.Cut(contentType, ";")
+	base = strings.TrimSpace(base)
+	switch base {
+	case "application/javascript", "application/json", "application/manifest+json", "application/xml", "image/svg+xml":
+		return true
+	}
+	return strings.HasPrefix(base, "text/")
+}
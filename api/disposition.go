@@ -0,0 +1,44 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+)
+
+// DispositionRule sets the Content-Disposition type, "attachment" or
+// "inline", of uploads matching Pattern, which follows the same rules as
+// ContentTypeRule.Pattern.
+type DispositionRule struct {
+	Pattern     string
+	Disposition string
+}
+
+// ValidateDisposition checks that disposition is a type a rule can set. The
+// filename parameter always comes from the object name, so parameters are
+// refused.
+func ValidateDisposition(disposition string) error {
+	switch strings.ToLower(disposition) {
+	case "attachment", "inline":
+		return nil
+	case "":
+		return fmt.Errorf("empty disposition")
+	}
+	if strings.Contains(disposition, ";") {
+		return fmt.Errorf("invalid disposition %q: the filename is taken from the object name", disposition)
+	}
+	return fmt.Errorf("invalid disposition %q (want attachment or inline)", disposition)
+}
+
+// ContentDisposition is the Content-Disposition an upload to objectPath is
+// sent with, or "" when no rule matches. Names that are not plain ASCII are
+// encoded as RFC 2231 describes.
+func (s *BCDNStorage) ContentDisposition(objectPath string) string {
+	for _, rule := range s.Dispositions {
+		if matchPattern(rule.Pattern, objectPath) {
+			return mime.FormatMediaType(strings.ToLower(rule.Disposition), map[string]string{"filename": path.Base(objectPath)})
+		}
+	}
+	return ""
+}
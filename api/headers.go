@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+)
+
+// HeaderRule adds a header to uploads matching Pattern, which follows the
+// same rules as ContentTypeRule.Pattern.
+type HeaderRule struct {
+	Pattern string
+	Name    string
+	Value   string
+}
+
+// ValidateHeader checks that name and value form a header line an upload can
+// carry. Headers the client sets itself are refused: Content-Type has its own
+// rules and Content-Encoding follows compression.
+func ValidateHeader(name, value string) error {
+	if name == "" {
+		return errors.New("empty header name")
+	}
+	for _, c := range name {
+		if !isTokenChar(c) {
+			return fmt.Errorf("invalid character %q in header name %q", c, name)
+		}
+	}
+	for _, c := range value {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return fmt.Errorf("invalid character %q in the value of %s", c, name)
+		}
+	}
+	switch textproto.CanonicalMIMEHeaderKey(name) {
+	case "Content-Type":
+		return errors.New("Content-Type is set by content type rules")
+	case "Accesskey", "Checksum", "Content-Encoding", "Content-Length", "Host", "Transfer-Encoding":
+		return fmt.Errorf("%s is set by the client and cannot be overridden", name)
+	}
+	return nil
+}
+
+// UploadHeader returns the extra headers an upload to objectPath is sent
+// with: UploadHeaders, overridden per header by the first matching rule,
+// and Content-Disposition from Dispositions last.
+func (s *BCDNStorage) UploadHeader(objectPath string) http.Header {
+	h := http.Header{}
+	for name, value := range s.UploadHeaders {
+		h.Set(name, value)
+	}
+	fromRule := map[string]bool{}
+	for _, rule := range s.HeaderRules {
+		key := textproto.CanonicalMIMEHeaderKey(rule.Name)
+		if fromRule[key] || !matchPattern(rule.Pattern, objectPath) {
+			continue
+		}
+		fromRule[key] = true
+		h.Set(key, rule.Value)
+	}
+	if d := s.ContentDisposition(objectPath); d != "" {
+		h.Set("Content-Disposition", d)
+	}
+	return h
+}
+
+func isTokenChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	switch c {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+)
+
+const defaultListWorkers = 5
+
+// ObjectPath is the path obj is addressed by below the zone root, without
+// the zone name and leading slashes, as used for the map keys of
+// ListRecursive.
+func ObjectPath(zoneName string, obj BCDNObject) string {
+	fullPath := obj.Path
+	if !strings.HasSuffix(fullPath, "/") && fullPath != "" {
+		fullPath += "/"
+	}
+	fullPath += obj.ObjectName
+
+	// Only one zone prefix is stripped, so a directory named like the zone
+	// keeps its name.
+	objPath := strings.TrimLeft(fullPath, "/")
+	objPath = strings.TrimPrefix(objPath, zoneName+"/")
+	objPath = strings.TrimLeft(objPath, "/")
+	return path.Clean(objPath)
+}
+
+// ListRecursive lists prefix and every directory below it and returns the
+// files keyed by ObjectPath. Leading and trailing slashes of prefix are
+// ignored.
+func (s *BCDNStorage) ListRecursive(prefix string) (map[string]BCDNObject, error) {
+	return s.ListRecursiveContext(context.Background(), prefix)
+}
+
+func (s *BCDNStorage) ListRecursiveContext(ctx context.Context, prefix string) (map[string]BCDNObject, error) {
+	objects := map[string]BCDNObject{}
+	var mu sync.Mutex
+	err := s.ListTreeContext(ctx, prefix, 0, func(obj BCDNObject) error {
+		if !obj.IsDirectory {
+			mu.Lock()
+			objects[ObjectPath(s.ZoneName, obj)] = obj
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// ListTreeContext walks prefix and the directories below it with up to
+// workers concurrent listings (zero selects a default) and calls fn for
+// every object, directories included. fn is called from several goroutines.
+// The first error from a listing or from fn stops the walk and is returned.
+func (s *BCDNStorage) ListTreeContext(ctx context.Context, prefix string, workers int, fn func(BCDNObject) error) error {
+	if workers <= 0 {
+		workers = defaultListWorkers
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	// pending counts directories queued or being listed; the queue closes
+	// once it drops to zero.
+	queue := make(chan string, 1024)
+	var pending sync.WaitGroup
+	enqueue := func(dir string) {
+		pending.Add(1)
+		go func() {
+			select {
+			case queue <- dir:
+			case <-ctx.Done():
+				pending.Done()
+			}
+		}()
+	}
+
+	var workersDone sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workersDone.Add(1)
+		go func() {
+			defer workersDone.Done()
+			for dir := range queue {
+				if ctx.Err() == nil {
+					err := s.ListFuncContext(ctx, dir, func(obj BCDNObject) error {
+						if obj.IsDirectory {
+							enqueue(ObjectPath(s.ZoneName, obj))
+						}
+						return fn(obj)
+					})
+					if err != nil {
+						fail(err)
+					}
+				}
+				pending.Done()
+			}
+		}()
+	}
+
+	root := strings.Trim(prefix, "/")
+	if root != "" {
+		root = path.Clean(root)
+	}
+	if root == "." {
+		root = ""
+	}
+	enqueue(root)
+	pending.Wait()
+	close(queue)
+	workersDone.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
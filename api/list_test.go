@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestObjectPath(t *testing.T) {
+	tests := []struct {
+		path, name string
+		want       string
+	}{
+		{"/zone/", "a.txt", "a.txt"},
+		{"/zone/docs/", "b.txt", "docs/b.txt"},
+		{"/zone/docs", "b.txt", "docs/b.txt"},
+		{"zone/docs/", "b.txt", "docs/b.txt"},
+		{"//zone//docs/", "b.txt", "docs/b.txt"},
+		{"/zone/zone/", "c.txt", "zone/c.txt"},
+		{"/zone/", "zone", "zone"},
+		{"", "a.txt", "a.txt"},
+		{"/zonefiles/", "d.txt", "zonefiles/d.txt"},
+	}
+	for _, tt := range tests {
+		got := ObjectPath("zone", BCDNObject{Path: tt.path, ObjectName: tt.name})
+		if got != tt.want {
+			t.Errorf("ObjectPath(%q, %q) = %q, want %q", tt.path, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListRecursive(t *testing.T) {
+	listings := map[string]string{
+		"/zone/": `[
+			{"Path": "/zone/", "ObjectName": "root.txt"},
+			{"Path": "/zone/", "ObjectName": "docs", "IsDirectory": true}
+		]`,
+		"/zone/docs/": `[
+			{"Path": "/zone/docs/", "ObjectName": "a.txt"},
+			{"Path": "/zone/docs/", "ObjectName": "zone", "IsDirectory": true}
+		]`,
+		"/zone/docs/zone/": `[
+			{"Path": "/zone/docs/zone/", "ObjectName": "b.txt"}
+		]`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Listing the zone root requests "/zone//".
+		p := strings.Replace(r.URL.Path, "//", "/", -1)
+		listing, ok := listings[p]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, listing)
+	}))
+	defer srv.Close()
+	s := &BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL, MaxRetries: -1}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"docs/a.txt", "docs/zone/b.txt", "root.txt"}},
+		{"/", []string{"docs/a.txt", "docs/zone/b.txt", "root.txt"}},
+		{"docs", []string{"docs/a.txt", "docs/zone/b.txt"}},
+		{"/docs/", []string{"docs/a.txt", "docs/zone/b.txt"}},
+		{"//docs//zone", []string{"docs/zone/b.txt"}},
+	}
+	for _, tt := range tests {
+		objects, err := s.ListRecursive(tt.prefix)
+		if err != nil {
+			t.Errorf("ListRecursive(%q): %v", tt.prefix, err)
+			continue
+		}
+		var got []string
+		for key := range objects {
+			got = append(got, key)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("ListRecursive(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
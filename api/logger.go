@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives everything the storage client and the syncer log. Errorf
+// is used for failures, Infof for progress and warnings and Debugf only in
+// verbose mode.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// WarnLogger is implemented by loggers that keep warnings apart from
+// progress. Loggers without it get warnings through Infof, prefixed with
+// "WARNING: ".
+type WarnLogger interface {
+	Warnf(format string, args ...interface{})
+}
+
+// Warnf logs a warning to l.
+func Warnf(l Logger, format string, args ...interface{}) {
+	if w, ok := l.(WarnLogger); ok {
+		w.Warnf(format, args...)
+		return
+	}
+	l.Infof("WARNING: "+format, args...)
+}
+
+// StdLogger writes to the standard library logger, prefixing debug and error
+// lines the way the command line tool always has.
+type StdLogger struct{}
+
+func (StdLogger) Debugf(format string, args ...interface{}) {
+	log.Printf("DEBUG: "+format, args...)
+}
+
+func (StdLogger) Infof(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+func (StdLogger) Warnf(format string, args ...interface{}) {
+	log.Printf("WARNING: "+format, args...)
+}
+
+func (StdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads a level name as accepted by --log-level.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) || (n == "warn" && strings.EqualFold(name, "warning")) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (want error, warn, info or debug)", name)
+}
+
+// LevelLogger passes messages at Level or more severe on to Logger. The
+// syncer writes its final summary to Logger directly, so it is shown at
+// every level.
+type LevelLogger struct {
+	Logger Logger
+	Level  Level
+}
+
+func (l LevelLogger) Debugf(format string, args ...interface{}) {
+	if l.Level >= LevelDebug {
+		l.Logger.Debugf(format, args...)
+	}
+}
+
+func (l LevelLogger) Infof(format string, args ...interface{}) {
+	if l.Level >= LevelInfo {
+		l.Logger.Infof(format, args...)
+	}
+}
+
+func (l LevelLogger) Warnf(format string, args ...interface{}) {
+	if l.Level >= LevelWarn {
+		Warnf(l.Logger, format, args...)
+	}
+}
+
+func (l LevelLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Errorf(format, args...)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const PurgeURL = "https://api.bunny.net/purge"
+
+// PurgeContext asks the CDN to drop its cached copy of a public URL.
+func (s *BCDNStorage) PurgeContext(ctx context.Context, publicURL string) error {
+	if s.BunnyAPIKey == "" {
+		return errors.New("purge requires an account API key")
+	}
+	endpoint := PurgeURL + "?async=false&url=" + url.QueryEscape(publicURL)
+	s.logDebug("Purging %s", publicURL)
+
+	resp, err := s.do(ctx, "purge", publicURL, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("AccessKey", s.BunnyAPIKey)
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("purge %s: %w", publicURL, err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Once fewer than this fraction of the advertised budget remains, requests
+// are spread evenly over the time left until the window resets.
+const rateLimitLowWater = 0.2
+
+// RateLimiter paces requests from the X-RateLimit-* (or RateLimit-*) headers
+// the server returns. Until such headers are seen it never delays. With a
+// fixed rate it also acts as a token bucket holding up to one second of
+// requests, whichever of the two is stricter wins.
+type RateLimiter struct {
+	mu        sync.Mutex
+	known     bool
+	limit     int
+	remaining int
+	reset     time.Time
+	next      time.Time
+
+	rate   float64
+	tokens float64
+	filled time.Time
+}
+
+// NewRateLimiter returns a limiter capped at requestsPerSecond, or one that
+// only follows server headers when it is 0.
+func NewRateLimiter(requestsPerSecond float64) *RateLimiter {
+	return &RateLimiter{rate: requestsPerSecond, tokens: math.Max(requestsPerSecond, 1), filled: time.Now()}
+}
+
+// reserve takes a token from the bucket and returns how long the caller has
+// to wait until that token is actually available.
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	burst := math.Max(l.rate, 1)
+	l.tokens = math.Min(burst, l.tokens+now.Sub(l.filled).Seconds()*l.rate)
+	l.filled = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	var delay time.Duration
+	if l.known && now.Before(l.reset) {
+		switch {
+		case l.remaining <= 0:
+			delay = l.reset.Sub(now)
+		case float64(l.remaining) < float64(l.limit)*rateLimitLowWater:
+			interval := l.reset.Sub(now) / time.Duration(l.remaining)
+			if l.next.Before(now) {
+				l.next = now
+			}
+			delay = l.next.Sub(now)
+			l.next = l.next.Add(interval)
+		}
+		l.remaining--
+	}
+	if wait := l.reserve(now); wait > delay {
+		delay = wait
+	}
+	l.mu.Unlock()
+
+	return sleepContext(ctx, delay)
+}
+
+// Observe records the budget advertised by a response and reports whether
+// the response carried rate-limit headers.
+func (l *RateLimiter) Observe(h http.Header) bool {
+	if l == nil {
+		return false
+	}
+	limit, okLimit := headerInt(h, "X-RateLimit-Limit", "RateLimit-Limit")
+	remaining, okRemaining := headerInt(h, "X-RateLimit-Remaining", "RateLimit-Remaining")
+	if !okLimit || !okRemaining {
+		return false
+	}
+	reset := time.Now().Add(time.Minute)
+	if v, ok := headerInt(h, "X-RateLimit-Reset", "RateLimit-Reset"); ok {
+		// Large values are Unix timestamps, small ones are seconds from now.
+		if v > 1000000000 {
+			reset = time.Unix(int64(v), 0)
+		} else {
+			reset = time.Now().Add(time.Duration(v) * time.Second)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.known = true
+	l.limit = limit
+	l.remaining = remaining
+	l.reset = reset
+	return true
+}
+
+func (l *RateLimiter) Snapshot() (limit, remaining int, reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit, l.remaining, l.reset
+}
+
+func headerInt(h http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
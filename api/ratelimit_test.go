@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	tests := []struct {
+		rate     float64
+		interval time.Duration
+		calls    int
+		window   time.Duration
+		max      int
+	}{
+		// Calls arriving faster than the rate are held to it after the burst.
+		{rate: 10, interval: time.Millisecond, calls: 1000, window: time.Second, max: 20},
+		{rate: 2, interval: 10 * time.Millisecond, calls: 200, window: time.Second, max: 4},
+		{rate: 0.5, interval: 100 * time.Millisecond, calls: 100, window: 10 * time.Second, max: 6},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		l := NewRateLimiter(tt.rate)
+		l.filled = start
+		allowed := 0
+		for i := 0; i < tt.calls; i++ {
+			now := start.Add(time.Duration(i) * tt.interval)
+			if now.Add(l.reserve(now)).Before(start.Add(tt.window)) {
+				allowed++
+			}
+		}
+		if allowed > tt.max {
+			t.Errorf("rate %v: %d calls started within %s, want at most %d", tt.rate, allowed, tt.window, tt.max)
+		}
+	}
+}
+
+func TestRateLimiterWaitConcurrent(t *testing.T) {
+	const rate, calls = 20, 30
+	l := NewRateLimiter(rate)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Wait(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	// A burst of rate calls passes at once, the rest at the fixed rate.
+	if elapsed, want := time.Since(start), time.Duration(calls-rate)*time.Second/rate*9/10; elapsed < want {
+		t.Errorf("%d calls took %s, want at least %s", calls, elapsed, want)
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	l := NewRateLimiter(1)
+	l.Wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err == nil {
+		t.Error("Wait returned nil for a canceled context while the bucket was empty")
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// regionHosts maps Bunny storage region codes to their endpoints. The
+// default (Falkenstein) region has no prefix.
+var regionHosts = map[string]string{
+	"de":  "storage.bunnycdn.com",
+	"uk":  "uk.storage.bunnycdn.com",
+	"se":  "se.storage.bunnycdn.com",
+	"ny":  "ny.storage.bunnycdn.com",
+	"la":  "la.storage.bunnycdn.com",
+	"sg":  "sg.storage.bunnycdn.com",
+	"syd": "syd.storage.bunnycdn.com",
+	"br":  "br.storage.bunnycdn.com",
+	"jh":  "jh.storage.bunnycdn.com",
+}
+
+// RegionEndpoint returns the base URL for a storage region code. An empty
+// code selects the default endpoint.
+func RegionEndpoint(region string) (string, error) {
+	region = strings.ToLower(strings.TrimSpace(region))
+	if region == "" {
+		return BaseURL, nil
+	}
+	host, ok := regionHosts[region]
+	if !ok {
+		codes := make([]string, 0, len(regionHosts))
+		for code := range regionHosts {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		return "", fmt.Errorf("unknown storage region %q (known: %s)", region, strings.Join(codes, ", "))
+	}
+	return "https://" + host, nil
+}
+
+// baseURL is the endpoint requests go to: Endpoint when set, otherwise the
+// one for Region, falling back to the default endpoint.
+func (s *BCDNStorage) baseURL() string {
+	if s.Endpoint != "" {
+		return strings.TrimRight(s.Endpoint, "/")
+	}
+	if endpoint, err := RegionEndpoint(s.Region); err == nil {
+		return endpoint
+	}
+	return BaseURL
+}
+
+// objectURL is the request URL for path in the zone. Every segment is
+// escaped on its own, so names with spaces, '#', '?', '%' or non-ASCII
+// characters address the object they name. '+' is escaped as well, since
+// some servers read it as a space.
+func (s *BCDNStorage) objectURL(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(seg), "+", "%2B")
+	}
+	return s.baseURL() + "/" + url.PathEscape(s.ZoneName) + "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestObjectURL(t *testing.T) {
+	s := &BCDNStorage{ZoneName: "zone", Endpoint: "https://storage.example/"}
+	tests := []struct {
+		path, want string
+	}{
+		{"a.txt", "https://storage.example/zone/a.txt"},
+		{"with space.txt", "https://storage.example/zone/with%20space.txt"},
+		{"c++/a+b.txt", "https://storage.example/zone/c%2B%2B/a%2Bb.txt"},
+		{"q?/hash#1.txt", "https://storage.example/zone/q%3F/hash%231.txt"},
+		{"100%.txt", "https://storage.example/zone/100%25.txt"},
+		{"café/ü.txt", "https://storage.example/zone/caf%C3%A9/%C3%BC.txt"},
+	}
+	for _, tt := range tests {
+		if got := s.objectURL(tt.path); got != tt.want {
+			t.Errorf("objectURL(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// Names with spaces and '+' reach the server as they are, and the names it
+// lists come back as the same object paths.
+func TestSpecialNamesRoundTrip(t *testing.T) {
+	names := []string{"with space.txt", "a+b.txt", "dir with space/c+d e.txt"}
+	stored := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/zone/")
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			stored[name] = string(body)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			if name == "dir with space/" {
+				fmt.Fprint(w, `[{"Path": "/zone/dir with space/", "ObjectName": "c+d e.txt"}]`)
+				return
+			}
+			content, ok := stored[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, content)
+		}
+	}))
+	defer srv.Close()
+	s := &BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL, MaxRetries: -1}
+
+	for _, name := range names {
+		if err := s.Upload(name, []byte("content of "+name), ""); err != nil {
+			t.Fatalf("Upload(%q): %v", name, err)
+		}
+		if _, ok := stored[name]; !ok {
+			t.Errorf("Upload(%q) stored %v", name, stored)
+		}
+		got, err := s.Get(name)
+		if err != nil || got != "content of "+name {
+			t.Errorf("Get(%q) = %q, %v", name, got, err)
+		}
+	}
+	objects, err := s.List("dir with space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || ObjectPath("zone", objects[0]) != "dir with space/c+d e.txt" {
+		t.Errorf("listed %+v, want dir with space/c+d e.txt", objects)
+	}
+}
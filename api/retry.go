@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 2
+	defaultRetryDelay = 500 * time.Millisecond
+
+	// maxRetryAfter bounds how long a Retry-After header may hold a worker;
+	// a longer delay fails the request instead of stalling the sync.
+	maxRetryAfter = 5 * time.Minute
+)
+
+type RetryRecord struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Path      string    `json:"path"`
+	Attempt   int       `json:"attempt"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error"`
+	BackoffMs int64     `json:"backoff_ms"`
+}
+
+// RetryLog appends one JSON line per retry attempt. It is safe for
+// concurrent use by multiple workers sharing the same storage.
+type RetryLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewRetryLog(w io.Writer) *RetryLog {
+	return &RetryLog{w: w}
+}
+
+func (l *RetryLog) Record(rec RetryRecord) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(line, '\n'))
+}
+
+// StatusError is returned for a request the server answered with a non-2xx
+// status. RetryAfter is the delay the server asked for in a Retry-After
+// header, or zero.
+type StatusError struct {
+	Op         string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s failed with status %d (retry after %s): %s", e.Op, e.StatusCode, e.RetryAfter, e.Body)
+	}
+	return fmt.Sprintf("%s failed with status %d: %s", e.Op, e.StatusCode, e.Body)
+}
+
+func (e *StatusError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+func (s *BCDNStorage) do(ctx context.Context, op, path string, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if req.Header.Get("AccessKey") == "" {
+			req.Header.Set("AccessKey", s.APIKey)
+		}
+
+		newConn := false
+		if s.Verbose {
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) { newConn = !info.Reused },
+			}))
+		}
+
+		status := 0
+		if err := s.Limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("%s request failed: %w", op, err)
+		}
+		resp, err := s.client().Do(req)
+		if err == nil && newConn {
+			s.logDebug("New connection to %s using %s", req.URL.Host, resp.Proto)
+		}
+		if err == nil && s.Limiter.Observe(resp.Header) && s.Verbose {
+			limit, remaining, reset := s.Limiter.Snapshot()
+			s.logDebug("Rate limit: %d/%d requests remaining, resets in %s", remaining, limit, time.Until(reset).Round(time.Second))
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("%s request failed: %w", op, err)
+			if ctx.Err() != nil {
+				return nil, lastErr
+			}
+		} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			status = resp.StatusCode
+			retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			lastErr = &StatusError{Op: op, StatusCode: resp.StatusCode, Body: string(body), RetryAfter: retryAfter}
+			if !retryableStatus(resp.StatusCode) {
+				return nil, lastErr
+			}
+		} else {
+			return resp, nil
+		}
+
+		if attempt > s.maxRetries() {
+			return nil, lastErr
+		}
+
+		backoff := s.retryDelay() << (attempt - 1)
+		backoff += time.Duration(rand.Int63n(int64(backoff)/2 + 1))
+		var statusErr *StatusError
+		if errors.As(lastErr, &statusErr) && statusErr.RetryAfter > 0 {
+			if statusErr.RetryAfter > maxRetryAfter {
+				return nil, lastErr
+			}
+			backoff = statusErr.RetryAfter
+		}
+		s.logDebug("Retrying %s %s in %s (attempt %d): %v", op, path, backoff, attempt, lastErr)
+		s.RetryLog.Record(RetryRecord{
+			Time:      time.Now(),
+			Operation: op,
+			Path:      path,
+			Attempt:   attempt,
+			Status:    status,
+			Error:     lastErr.Error(),
+			BackoffMs: backoff.Milliseconds(),
+		})
+		if err := sleepContext(ctx, backoff); err != nil {
+			return nil, lastErr
+		}
+	}
+}
+
+// parseRetryAfter reads a Retry-After value, either delay seconds or an HTTP
+// date, as a duration from now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// maxRetries is the number of retries after the first attempt. Zero selects
+// the default, a negative value disables retries.
+func (s *BCDNStorage) maxRetries() int {
+	switch {
+	case s.MaxRetries < 0:
+		return 0
+	case s.MaxRetries == 0:
+		return defaultMaxRetries
+	}
+	return s.MaxRetries
+}
+
+func (s *BCDNStorage) retryDelay() time.Duration {
+	if s.RetryDelay <= 0 {
+		return defaultRetryDelay
+	}
+	return s.RetryDelay
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusErrors(t *testing.T) {
+	sentinels := []error{ErrNotFound, ErrUnauthorized, ErrRateLimited}
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusForbidden, nil},
+		{http.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("server says no"))
+			}))
+			defer srv.Close()
+			s := &BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL, MaxRetries: -1}
+
+			_, err := s.Get("file.txt")
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("got %v, want a *StatusError", err)
+			}
+			if statusErr.StatusCode != tt.status || statusErr.Op != "get" || statusErr.Body != "server says no" {
+				t.Errorf("got %+v", statusErr)
+			}
+			for _, sentinel := range sentinels {
+				if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+					t.Errorf("errors.Is(err, %v) = %v", sentinel, got)
+				}
+			}
+		})
+	}
+}
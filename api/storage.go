@@ -2,23 +2,72 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"mime"
 	"net/http"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
 const BaseURL = "https://storage.bunnycdn.com"
 
+var (
+	ErrLengthMismatch   = errors.New("stored length does not match uploaded length")
+	ErrChecksumMismatch = errors.New("server rejected the upload checksum")
+	ErrNotFound         = errors.New("object not found")
+	ErrUnauthorized     = errors.New("access key rejected")
+	ErrRateLimited      = errors.New("rate limited")
+
+	// ErrNoAccessControl is returned for an upload that asks for restricted
+	// access: Bunny Storage keeps no per-object ACL, so every object is
+	// readable through the pull zones connected to its storage zone.
+	ErrNoAccessControl = errors.New("storage zone has no per-object access control")
+)
+
 type BCDNStorage struct {
 	ZoneName string
 	APIKey   string
 	Verbose  bool
+	RetryLog *RetryLog
+	Logger   Logger
+
+	Region   string
+	Endpoint string
+
+	// BunnyAPIKey is the account API key, needed only for Purge; the storage
+	// zone password in APIKey is not accepted by api.bunny.net.
+	BunnyAPIKey string
+
+	MaxRetries int
+	RetryDelay time.Duration
+	// Timeout fails a request attempt that makes no progress for this long;
+	// a transfer that keeps moving is not cut off. See timeoutClient.
+	Timeout time.Duration
+
+	ForceHTTP1          bool
+	VerifyContentLength bool
+	Limiter             *RateLimiter
+
+	// ContentTypes forces the Content-Type of matching uploads; the first
+	// matching rule wins over DetectContentType.
+	ContentTypes []ContentTypeRule
+
+	// UploadHeaders are sent with every upload and HeaderRules with matching
+	// ones, e.g. Cache-Control; see UploadHeader.
+	UploadHeaders map[string]string
+	HeaderRules   []HeaderRule
+
+	// Dispositions sets Content-Disposition on matching uploads, naming the
+	// object as the file to save; the first matching rule wins.
+	Dispositions []DispositionRule
+
+	// Client overrides the shared pooled client, e.g. for tests or proxies.
+	// ForceHTTP1 has no effect when it is set.
+	Client *http.Client
 }
 
 type BCDNObject struct {
@@ -41,159 +90,384 @@ type BCDNTime struct {
 	time.Time
 }
 
+// UnmarshalJSON accepts the timestamps the storage API returns: without a
+// zone, which is UTC, or in RFC 3339 form with "Z" or a numeric offset. Any
+// number of fractional second digits is allowed. Null or an empty string
+// leaves the time zero.
 func (t *BCDNTime) UnmarshalJSON(buf []byte) error {
 	trimmed := strings.Trim(string(buf), `"`)
-	formats := []string{
-		"2006-01-02T15:04:05",
-		"2006-01-02T15:04:05.0",
-		"2006-01-02T15:04:05.00",
-		"2006-01-02T15:04:05.000",
-	}
-	var latestError error
-	for _, format := range formats {
-		tt, err := time.Parse(format, trimmed)
-		if err == nil {
+	if trimmed == "" || trimmed == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	if tt, err := time.ParseInLocation("2006-01-02T15:04:05", trimmed, time.UTC); err == nil {
+		t.Time = tt
+		return nil
+	}
+	for _, format := range []string{time.RFC3339Nano, "2006-01-02T15:04:05Z0700"} {
+		if tt, err := time.Parse(format, trimmed); err == nil {
 			t.Time = tt
 			return nil
 		}
-		latestError = err
 	}
-	return latestError
+	return fmt.Errorf("unrecognized timestamp %q", trimmed)
+}
+
+func (s *BCDNStorage) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return StdLogger{}
 }
 
 func (s *BCDNStorage) logDebug(format string, args ...interface{}) {
 	if s.Verbose {
-		log.Printf("DEBUG: [API] "+format, args...)
+		s.logger().Debugf("[API] "+format, args...)
 	}
 }
 
 func (s *BCDNStorage) List(path string) ([]BCDNObject, error) {
-	url := fmt.Sprintf("%s/%s/%s/", BaseURL, s.ZoneName, path)
-	s.logDebug("Listing directory: %s", path)
-	
-	req, err := http.NewRequest("GET", url, nil)
+	return s.ListContext(context.Background(), path)
+}
+
+func (s *BCDNStorage) ListContext(ctx context.Context, path string) ([]BCDNObject, error) {
+	var objects []BCDNObject
+	err := s.ListFuncContext(ctx, path, func(obj BCDNObject) error {
+		objects = append(objects, obj)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
-	req.Header.Set("AccessKey", s.APIKey)
-	
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	return objects, nil
+}
+
+// ListFunc streams a directory listing, decoding entries one at a time and
+// passing each to fn, so the raw response is never held in memory. An error
+// returned by fn stops the listing and is returned as is.
+func (s *BCDNStorage) ListFunc(path string, fn func(BCDNObject) error) error {
+	return s.ListFuncContext(context.Background(), path, fn)
+}
+
+func (s *BCDNStorage) ListFuncContext(ctx context.Context, path string, fn func(BCDNObject) error) error {
+	url := s.objectURL(path) + "/"
+	s.logDebug("Listing directory: %s", path)
+
+	resp, err := s.do(ctx, "list", path, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", url, nil)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("list request failed: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
-	
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("list failed with status %d: %s", resp.StatusCode, string(body))
+
+	dec := json.NewDecoder(resp.Body)
+	if tok, err := dec.Token(); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	} else if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("failed to parse response: expected array, got %v", tok)
 	}
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	for dec.More() {
+		var obj BCDNObject
+		if err := dec.Decode(&obj); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
 	}
-	
-	var apiResponse []BCDNObject
-	err = json.Unmarshal(body, &apiResponse)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return nil
+}
+
+var errStopListing = errors.New("stop listing")
+
+// CheckAccess makes a single listing request for the zone root, so a wrong
+// zone name or access key is reported before any other work is done. The
+// error wraps ErrUnauthorized or ErrNotFound for those cases.
+func (s *BCDNStorage) CheckAccess() error {
+	return s.CheckAccessContext(context.Background())
+}
+
+func (s *BCDNStorage) CheckAccessContext(ctx context.Context) error {
+	err := s.ListFuncContext(ctx, "", func(BCDNObject) error { return errStopListing })
+	switch {
+	case err == nil, errors.Is(err, errStopListing):
+		return nil
+	case errors.Is(err, ErrUnauthorized):
+		return fmt.Errorf("authentication failed: storage zone %q rejected the access key: %w", s.ZoneName, err)
+	case errors.Is(err, ErrNotFound):
+		return fmt.Errorf("storage zone %q not found: %w", s.ZoneName, err)
 	}
-	
-	return apiResponse, nil
+	return fmt.Errorf("cannot reach storage zone %q: %w", s.ZoneName, err)
 }
 
+// Get returns the content of the object at path. The whole body is held in
+// memory, twice while it is converted to a string, so it suits small text
+// objects such as manifests; use DownloadStream for files.
 func (s *BCDNStorage) Get(path string) (string, error) {
-	url := fmt.Sprintf("%s/%s/%s", BaseURL, s.ZoneName, path)
+	return s.GetContext(context.Background(), path)
+}
+
+func (s *BCDNStorage) GetContext(ctx context.Context, path string) (string, error) {
+	var body bytes.Buffer
+	if _, err := s.DownloadStreamContext(ctx, path, &body); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
+
+// DownloadStream copies the content of the object at path to w as it
+// arrives and returns the number of bytes written. A failed request is
+// retried like any other, but once the body has started, an error while
+// copying it is returned as is, since w may already hold part of it.
+func (s *BCDNStorage) DownloadStream(path string, w io.Writer) (int64, error) {
+	return s.DownloadStreamContext(context.Background(), path, w)
+}
+
+func (s *BCDNStorage) DownloadStreamContext(ctx context.Context, path string, w io.Writer) (int64, error) {
+	url := s.objectURL(path)
 	s.logDebug("Running GET for %s", url)
-	
-	req, err := http.NewRequest("GET", url, nil)
+
+	resp, err := s.do(ctx, "get", path, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		// The stored bytes are wanted as they are, so they match the listed
+		// checksum; without this the transport would decompress a gzip
+		// Content-Encoding on its own.
+		req.Header.Set("Accept-Encoding", "identity")
+		return req, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return 0, err
 	}
-	req.Header.Set("AccessKey", s.APIKey)
-	
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	defer resp.Body.Close()
+
+	n, err := io.Copy(w, resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("get request failed: %w", err)
+		return n, fmt.Errorf("failed to read response: %w", err)
+	}
+	return n, nil
+}
+
+// Stat returns the metadata of a single object without listing its parent.
+// A missing object yields an error wrapping ErrNotFound.
+func (s *BCDNStorage) Stat(path string) (BCDNObject, error) {
+	return s.StatContext(context.Background(), path)
+}
+
+func (s *BCDNStorage) StatContext(ctx context.Context, path string) (BCDNObject, error) {
+	url := s.objectURL(path)
+	s.logDebug("Describing %s/%s", s.ZoneName, path)
+
+	resp, err := s.do(ctx, "stat", path, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "DESCRIBE", url, nil)
+	})
+	if err != nil {
+		return BCDNObject{}, err
 	}
 	defer resp.Body.Close()
-	
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("get failed with status %d: %s", resp.StatusCode, string(body))
+
+	var obj BCDNObject
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return BCDNObject{}, fmt.Errorf("failed to parse response: %w", err)
 	}
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+	return obj, nil
+}
+
+// Exists reports whether an object is stored at path. Only a 404 counts as
+// absent; any other failure is returned as an error.
+func (s *BCDNStorage) Exists(path string) (bool, error) {
+	return s.ExistsContext(context.Background(), path)
+}
+
+func (s *BCDNStorage) ExistsContext(ctx context.Context, path string) (bool, error) {
+	_, err := s.StatContext(ctx, path)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
 	}
-	
-	return string(body), nil
+	return err == nil, err
 }
 
 func (s *BCDNStorage) Upload(path string, content []byte, checksum string) error {
-	contentType := detectContentType(path)
-	url := fmt.Sprintf("%s/%s/%s", BaseURL, s.ZoneName, path)
-	s.logDebug("Uploading %s/%s (Type: %s)", s.ZoneName, path, contentType)
-	
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(content))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("AccessKey", s.APIKey)
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Content-Type", contentType)
-	
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	return s.UploadContext(context.Background(), path, content, checksum)
+}
+
+func (s *BCDNStorage) UploadContext(ctx context.Context, path string, content []byte, checksum string) error {
+	return s.UploadStreamContext(ctx, path, bytes.NewReader(content), int64(len(content)), checksum)
+}
+
+// UploadStream sends size bytes read from r as the object body without
+// buffering them. Retries seek r back to the offset it had when the upload
+// started when it is an io.Seeker, such as an *os.File; any other reader
+// gets a single attempt.
+func (s *BCDNStorage) UploadStream(path string, r io.Reader, size int64, checksum string) error {
+	return s.UploadStreamContext(context.Background(), path, r, size, checksum)
+}
+
+func (s *BCDNStorage) UploadStreamContext(ctx context.Context, path string, r io.Reader, size int64, checksum string) error {
+	return s.uploadStream(ctx, path, r, size, checksum, "")
+}
+
+// UploadEncodedContext uploads content that is already compressed with
+// encoding (e.g. "gzip"), setting Content-Encoding so the CDN serves it as
+// such. The checksum is that of the encoded bytes.
+func (s *BCDNStorage) UploadEncodedContext(ctx context.Context, path string, content []byte, checksum, encoding string) error {
+	return s.uploadStream(ctx, path, bytes.NewReader(content), int64(len(content)), checksum, encoding)
+}
+
+func (s *BCDNStorage) uploadStream(ctx context.Context, path string, r io.Reader, size int64, checksum, encoding string) error {
+	contentType := s.ContentType(path)
+	header := s.UploadHeader(path)
+	url := s.objectURL(path)
+	s.logDebug("Uploading %s/%s (Type: %s, Size: %d)", s.ZoneName, path, contentType, size)
+
+	// Retries rewind to where the body started. Each attempt reads through
+	// its own attemptBody, closed before the rewind, so a transport still
+	// reading the previous attempt cannot interleave with the next one.
+	var start int64
+	seeker, canRewind := r.(io.Seeker)
+	if canRewind {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			canRewind = false
+		}
+	}
+	var prev *attemptBody
+	resp, err := s.do(ctx, "upload", path, func(ctx context.Context) (*http.Request, error) {
+		if prev != nil {
+			prev.Close()
+			if !canRewind {
+				return nil, errors.New("body cannot be rewound for a retry")
+			}
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		prev = &attemptBody{r: r}
+		var body io.Reader = prev
+		if size == 0 {
+			body = http.NoBody
+		}
+		req, err := http.NewRequestWithContext(ctx, "PUT", url, body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Accept", "*/*")
+		req.Header.Set("Content-Type", contentType)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		if checksum != "" {
+			req.Header.Set("Checksum", strings.ToUpper(checksum))
+		}
+		return req, nil
+	})
+	var statusErr *StatusError
+	if checksum != "" && errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusBadRequest &&
+		strings.Contains(strings.ToLower(statusErr.Body), "checksum") {
+		return fmt.Errorf("upload %s: %w: %v", path, ErrChecksumMismatch, err)
+	}
 	if err != nil {
-		return fmt.Errorf("upload request failed: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
-	
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
+
+	if s.VerifyContentLength {
+		return s.checkStoredLength(path, resp, size)
 	}
-	
 	return nil
 }
 
 func (s *BCDNStorage) Delete(path string) error {
-	url := fmt.Sprintf("%s/%s/%s", BaseURL, s.ZoneName, path)
+	return s.DeleteContext(context.Background(), path)
+}
+
+func (s *BCDNStorage) DeleteContext(ctx context.Context, path string) error {
+	url := s.objectURL(path)
 	s.logDebug("Deleting %s/%s", s.ZoneName, path)
-	
-	req, err := http.NewRequest("DELETE", url, nil)
+
+	resp, err := s.do(ctx, "delete", path, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
-	req.Header.Set("AccessKey", s.APIKey)
-	
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp.Body.Close()
+
+	return nil
+}
+
+// DeleteDirectory removes the directory at path and everything below it with
+// a single request.
+func (s *BCDNStorage) DeleteDirectory(path string) error {
+	return s.DeleteDirectoryContext(context.Background(), path)
+}
+
+func (s *BCDNStorage) DeleteDirectoryContext(ctx context.Context, path string) error {
+	return s.DeleteContext(ctx, strings.TrimSuffix(path, "/")+"/")
+}
+
+// checkStoredLength compares any size the upload response reports against
+// what was sent. Responses without size information pass unchecked.
+func (s *BCDNStorage) checkStoredLength(path string, resp *http.Response, sent int64) error {
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("delete request failed: %w", err)
+		return fmt.Errorf("failed to read upload response: %w", err)
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("delete failed with status %d: %s", resp.StatusCode, string(body))
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) != nil {
+		s.logDebug("Upload response for %s is not JSON, skipping length check", path)
+		return nil
+	}
+	for key, value := range fields {
+		switch strings.ToLower(key) {
+		case "length", "size", "contentlength", "filesize":
+		default:
+			continue
+		}
+		stored, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		if int64(stored) != sent {
+			return fmt.Errorf("upload %s: %w (sent %d bytes, server reports %d)", path, ErrLengthMismatch, sent, int64(stored))
+		}
+		return nil
 	}
-	
+	s.logDebug("Upload response for %s carries no length, skipping length check", path)
 	return nil
 }
 
-func detectContentType(path string) string {
-	ext := filepath.Ext(path)
-	if ext == "" {
-		return "application/octet-stream"
-	}
-	contentType := mime.TypeByExtension(ext)
-	if contentType == "" {
-		return "application/octet-stream"
+// attemptBody is the request body of one upload attempt. Reads fail once it
+// is closed, which waits for a read in progress to finish.
+type attemptBody struct {
+	mu     sync.Mutex
+	r      io.Reader
+	closed bool
+}
+
+func (b *attemptBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, errors.New("read from a finished upload attempt")
 	}
-	return contentType
+	return b.r.Read(p)
+}
+
+func (b *attemptBody) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	return nil
 }
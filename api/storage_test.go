@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUploadStreamRetryRewindsBody(t *testing.T) {
+	const prefix, content = "skipped header|", "the object body"
+	tests := []struct {
+		name      string
+		reader    func(t *testing.T) io.Reader
+		wantPuts  int
+		wantError bool
+	}{
+		{"file at an offset", func(t *testing.T) io.Reader {
+			name := filepath.Join(t.TempDir(), "body")
+			if err := os.WriteFile(name, []byte(prefix+content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { f.Close() })
+			if _, err := f.Seek(int64(len(prefix)), io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			return f
+		}, 2, false},
+		{"bytes reader at an offset", func(t *testing.T) io.Reader {
+			r := bytes.NewReader([]byte(prefix + content))
+			r.Seek(int64(len(prefix)), io.SeekStart)
+			return r
+		}, 2, false},
+		{"reader without Seek", func(t *testing.T) io.Reader {
+			return io.MultiReader(strings.NewReader(content))
+		}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var bodies []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if len(bodies) == 0 {
+					// Read part of the body before failing, as a server
+					// dropping the upload midway would.
+					part := make([]byte, 4)
+					io.ReadFull(r.Body, part)
+					bodies = append(bodies, string(part))
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer srv.Close()
+
+			s := &BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL, RetryDelay: time.Millisecond}
+			err := s.UploadStream("file.txt", tt.reader(t), int64(len(content)), "")
+			if tt.wantError != (err != nil) {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if len(bodies) != tt.wantPuts {
+				t.Fatalf("got %d PUTs, want %d", len(bodies), tt.wantPuts)
+			}
+			if !tt.wantError && bodies[1] != content {
+				t.Errorf("retried PUT sent %q, want %q", bodies[1], content)
+			}
+		})
+	}
+}
+
+func TestBCDNTimeUnmarshal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{`"2024-03-05T10:20:30"`, time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC), false},
+		{`"2024-03-05T10:20:30.123"`, time.Date(2024, 3, 5, 10, 20, 30, 123000000, time.UTC), false},
+		{`"2024-03-05T10:20:30.1234567"`, time.Date(2024, 3, 5, 10, 20, 30, 123456700, time.UTC), false},
+		{`"2024-03-05T10:20:30Z"`, time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC), false},
+		{`"2024-03-05T10:20:30.5Z"`, time.Date(2024, 3, 5, 10, 20, 30, 500000000, time.UTC), false},
+		{`"2024-03-05T12:20:30+02:00"`, time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC), false},
+		{`"2024-03-05T05:20:30-0500"`, time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC), false},
+		{`""`, time.Time{}, false},
+		{`null`, time.Time{}, false},
+		{`"05/03/2024 10:20"`, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		var got BCDNTime
+		err := got.UnmarshalJSON([]byte(tt.in))
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), strings.Trim(tt.in, `"`)) {
+				t.Errorf("UnmarshalJSON(%s) error = %v, want one naming the input", tt.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("UnmarshalJSON(%s): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("UnmarshalJSON(%s) = %s, want %s", tt.in, got.Time, tt.want)
+		}
+	}
+}
+
+func TestListFuncStreamsLargeListing(t *testing.T) {
+	const objects = 200000
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := bufio.NewWriter(w)
+		bw.WriteString("[")
+		for i := 0; i < objects; i++ {
+			if i > 0 {
+				bw.WriteString(",")
+			}
+			fmt.Fprintf(bw, `{"Guid": "%08d-0000-0000-0000-000000000000", "Path": "/zone/big/", "ObjectName": "file-%06d.html", "Length": %d, "LastChanged": "2024-01-01T00:00:00.000", "Checksum": "%064d"}`, i, i, i, i)
+		}
+		bw.WriteString("]")
+		bw.Flush()
+	}))
+	defer srv.Close()
+	s := &BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL, MaxRetries: -1}
+
+	var before, during runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	var peak uint64
+	count := 0
+	err := s.ListFunc("big", func(obj BCDNObject) error {
+		if obj.ObjectName != fmt.Sprintf("file-%06d.html", count) {
+			return fmt.Errorf("object %d is named %q", count, obj.ObjectName)
+		}
+		count++
+		if count%25000 == 0 {
+			runtime.GC()
+			runtime.ReadMemStats(&during)
+			peak = max(peak, during.HeapAlloc)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != objects {
+		t.Fatalf("listed %d objects, want %d", count, objects)
+	}
+	// The response is about 45 MB; decoding it one object at a time keeps
+	// the heap far below that.
+	if grown := int64(peak) - int64(before.HeapAlloc); grown > 8<<20 {
+		t.Errorf("heap grew by %d bytes while listing", grown)
+	}
+
+	stop := errors.New("stop")
+	count = 0
+	err = s.ListFunc("big", func(BCDNObject) error {
+		if count++; count == 10 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 10 {
+		t.Errorf("got %v after %d objects, want the callback's error after 10", err, count)
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Workers hit a single host, so keep enough idle connections around for
+// any realistic --concurrency instead of the default two.
+const maxIdleConnsPerHost = 64
+
+var (
+	transportOnce  sync.Once
+	http2Transport *http.Transport
+	http1Transport *http.Transport
+	http2Client    *http.Client
+	http1Client    *http.Client
+)
+
+// sharedTransport returns a process-wide transport so connections are pooled
+// across requests. HTTP/2 is negotiated explicitly via ALPN unless forceHTTP1
+// is set, in which case the TLS handshake only offers HTTP/1.1.
+func sharedTransport(forceHTTP1 bool) *http.Transport {
+	transportOnce.Do(func() {
+		http2Transport = http.DefaultTransport.(*http.Transport).Clone()
+		http2Transport.ForceAttemptHTTP2 = true
+		http2Transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+
+		http1Transport = http.DefaultTransport.(*http.Transport).Clone()
+		http1Transport.ForceAttemptHTTP2 = false
+		http1Transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		http1Transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+
+		http2Client = &http.Client{Transport: http2Transport}
+		http1Client = &http.Client{Transport: http1Transport}
+	})
+	if forceHTTP1 {
+		return http1Transport
+	}
+	return http2Transport
+}
+
+// client returns the injected Client, or the shared one matching ForceHTTP1.
+// A Timeout gets a Client of its own; see timeoutClient.
+func (s *BCDNStorage) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	if s.Timeout > 0 {
+		return timeoutClient(s.ForceHTTP1, s.Timeout)
+	}
+	sharedTransport(s.ForceHTTP1)
+	if s.ForceHTTP1 {
+		return http1Client
+	}
+	return http2Client
+}
+
+type timeoutKey struct {
+	forceHTTP1 bool
+	timeout    time.Duration
+}
+
+var (
+	timeoutMu      sync.Mutex
+	timeoutClients = map[timeoutKey]*http.Client{}
+)
+
+// timeoutClient returns a pooled client that gives up on a request once it
+// makes no progress for timeout: while connecting, during the TLS handshake,
+// while waiting for the response headers, or between two reads of either
+// body. A transfer that keeps moving may take as long as it needs, so
+// http.Client.Timeout, which would cap the whole exchange, stays unset.
+func timeoutClient(forceHTTP1 bool, timeout time.Duration) *http.Client {
+	timeoutMu.Lock()
+	defer timeoutMu.Unlock()
+	key := timeoutKey{forceHTTP1, timeout}
+	if c, ok := timeoutClients[key]; ok {
+		return c
+	}
+	transport := sharedTransport(forceHTTP1).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	c := &http.Client{Transport: &stallTransport{base: transport, timeout: timeout}}
+	timeoutClients[key] = c
+	return c
+}
+
+// stallError is returned for a request that made no progress for timeout.
+// Like the transport's own timeouts it is a net.Error, so the request is
+// retried.
+type stallError struct {
+	timeout time.Duration
+}
+
+func (e *stallError) Error() string {
+	return fmt.Sprintf("no progress for %s", e.timeout)
+}
+
+func (e *stallError) Timeout() bool   { return true }
+func (e *stallError) Temporary() bool { return true }
+
+// stallTransport cancels a request once neither body has moved for timeout.
+type stallTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *stallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	stalled := &stallError{timeout: t.timeout}
+	w := &stallWatch{ctx: ctx, timeout: t.timeout}
+	w.timer = time.AfterFunc(t.timeout, func() { cancel(stalled) })
+	done := func() {
+		w.timer.Stop()
+		cancel(nil)
+	}
+
+	req = req.WithContext(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &stallBody{ReadCloser: req.Body, w: w}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		err = w.cause(err)
+		done()
+		return nil, err
+	}
+	w.progress()
+	resp.Body = &stallBody{ReadCloser: resp.Body, w: w, done: done}
+	return resp, nil
+}
+
+type stallWatch struct {
+	ctx     context.Context
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (w *stallWatch) progress() {
+	w.timer.Reset(w.timeout)
+}
+
+// cause replaces the cancellation error of a stalled request with the
+// stallError that caused it.
+func (w *stallWatch) cause(err error) error {
+	var stalled *stallError
+	if errors.As(context.Cause(w.ctx), &stalled) {
+		return stalled
+	}
+	return err
+}
+
+type stallBody struct {
+	io.ReadCloser
+	w    *stallWatch
+	done func()
+}
+
+func (b *stallBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.w.progress()
+	}
+	if err != nil && err != io.EOF {
+		err = b.w.cause(err)
+	}
+	return n, err
+}
+
+func (b *stallBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.done != nil {
+		b.done()
+	}
+	return err
+}
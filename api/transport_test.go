@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowReader returns its data in chunks with a pause before each one.
+type slowReader struct {
+	data  []byte
+	chunk int
+	pause time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.pause)
+	n := copy(p[:min(len(p), r.chunk)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestTimeout(t *testing.T) {
+	const timeout = 150 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+
+	mux := http.NewServeMux()
+	// No response headers before the client gives up.
+	mux.HandleFunc("/zone/headers.txt", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	// Part of the body, then nothing.
+	mux.HandleFunc("/zone/body.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first part"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	// A body that keeps moving for several timeouts in a row.
+	mux.HandleFunc("/zone/slow.txt", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != strings.Repeat("u", 10) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		for i := 0; i < 10; i++ {
+			w.Write([]byte("d"))
+			w.(http.Flusher).Flush()
+			time.Sleep(timeout / 3)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	s := &BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL, Timeout: timeout, MaxRetries: -1}
+
+	tests := []struct {
+		name    string
+		call    func() error
+		timeout bool
+	}{
+		{"stalled before headers", func() error {
+			_, err := s.Get("headers.txt")
+			return err
+		}, true},
+		{"stalled body", func() error {
+			_, err := s.DownloadStream("body.txt", io.Discard)
+			return err
+		}, true},
+		{"slow download", func() error {
+			var buf bytes.Buffer
+			if _, err := s.DownloadStream("slow.txt", &buf); err != nil {
+				return err
+			}
+			if buf.String() != strings.Repeat("d", 10) {
+				t.Errorf("downloaded %q", buf.String())
+			}
+			return nil
+		}, false},
+		{"slow upload", func() error {
+			r := &slowReader{data: []byte(strings.Repeat("u", 10)), chunk: 1, pause: timeout / 3}
+			return s.UploadStream("slow.txt", r, 10, "")
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			err := tt.call()
+			elapsed := time.Since(start)
+			if !tt.timeout {
+				if err != nil {
+					t.Fatalf("unexpected error after %s: %v", elapsed, err)
+				}
+				if elapsed < 2*timeout {
+					t.Fatalf("transfer took %s, expected it to outlast the timeout", elapsed)
+				}
+				return
+			}
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Fatalf("got %v, want a timeout error", err)
+			}
+			if elapsed > 10*timeout {
+				t.Fatalf("timed out after %s, want about %s", elapsed, timeout)
+			}
+		})
+	}
+}
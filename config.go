@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultProfilesFile = "bunny-sync-profiles.json"
+	defaultConfigFile   = "bunny-sync.yaml"
+)
+
+// settings holds flag values loaded from a file, keyed by flag name. The
+// positional source and zone arguments use the keys "source" and "zone";
+// "source" may also be a list of directories.
+type settings map[string]interface{}
+
+type target struct {
+	sources []string
+	zone    string
+}
+
+// newTarget reads the positional arguments: one or more sources followed by
+// the zone. A single argument is the source, with the zone from a profile.
+func newTarget(args []string) target {
+	switch len(args) {
+	case 0:
+		return target{}
+	case 1:
+		return target{sources: args}
+	default:
+		return target{sources: args[:len(args)-1], zone: args[len(args)-1]}
+	}
+}
+
+func loadProfile(file, name string) (settings, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read profiles file: %w", err)
+	}
+	var profiles map[string]settings
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid profiles file %s: %w", file, err)
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("profile %q not found in %s (available: %s)", name, file, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// loadConfig reads a settings file: JSON when the name ends in .json, flat
+// YAML otherwise. Environment variables in string values are expanded.
+func loadConfig(file string) (settings, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+	var values settings
+	if strings.HasSuffix(file, ".json") {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("invalid config file: %w", err)
+		}
+	} else if values, err = parseYAMLSettings(data); err != nil {
+		return nil, err
+	}
+	if err := values.expandEnv(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// expandEnv replaces $NAME, ${NAME} and ${NAME:-default} in every string
+// value, including list items, with the variable's value.
+func (values settings) expandEnv() error {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch v := values[key].(type) {
+		case string:
+			expanded, err := expandEnv(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			values[key] = expanded
+		case []interface{}:
+			for i, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					continue
+				}
+				expanded, err := expandEnv(str)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				v[i] = expanded
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnv expands the variable references in s. An unset variable is an
+// error unless the reference gives a default, which is also used when the
+// variable is empty. "$$" is a literal dollar sign, and a "$" not followed
+// by a name or "{" is kept as is.
+func expandEnv(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i+1:]
+
+		var name, def string
+		hasDefault := false
+		switch {
+		case strings.HasPrefix(s, "$"):
+			b.WriteByte('$')
+			s = s[1:]
+			continue
+		case strings.HasPrefix(s, "{"):
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %q", "$"+s)
+			}
+			name, def, hasDefault = strings.Cut(s[1:end], ":-")
+			s = s[end+1:]
+			if name == "" || envNameLen(name) != len(name) {
+				return "", fmt.Errorf("invalid variable name %q", name)
+			}
+		default:
+			n := envNameLen(s)
+			if n == 0 {
+				b.WriteByte('$')
+				continue
+			}
+			name, s = s[:n], s[n:]
+		}
+
+		value, ok := os.LookupEnv(name)
+		switch {
+		case hasDefault && value == "":
+			value = def
+		case !ok:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(value)
+	}
+}
+
+// envNameLen returns the length of the variable name at the start of s.
+func envNameLen(s string) int {
+	for i, r := range s {
+		letter := r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// parseYAMLSettings reads the YAML subset a settings file needs: "key: value"
+// pairs and lists, either inline as [a, b] or as "- item" lines below a key
+// without a value. Comments and quoted strings are supported; nested maps,
+// anchors and multi-line strings are not.
+func parseYAMLSettings(data []byte) (settings, error) {
+	values := settings{}
+	listKey := ""
+	for n, raw := range strings.Split(string(data), "\n") {
+		line := strings.TrimSpace(stripYAMLComment(raw))
+		if line == "" || line == "---" {
+			continue
+		}
+		if item, ok := strings.CutPrefix(line, "-"); ok && (item == "" || item[0] == ' ') {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", n+1)
+			}
+			values[listKey] = append(values[listKey].([]interface{}), unquoteYAML(strings.TrimSpace(item)))
+			continue
+		}
+		if raw[0] == ' ' || raw[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested values are not supported", n+1)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", n+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", n+1, key)
+		}
+		listKey = ""
+		switch {
+		case value == "":
+			values[key] = []interface{}{}
+			listKey = key
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			items := []interface{}{}
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, unquoteYAML(item))
+				}
+			}
+			values[key] = items
+		case strings.HasPrefix(value, "{") || strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">"):
+			return nil, fmt.Errorf("line %d: nested values are not supported", n+1)
+		default:
+			values[key] = unquoteYAML(value)
+		}
+	}
+	return values, nil
+}
+
+// stripYAMLComment drops a # comment that starts the line or follows a
+// space, outside of quotes.
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteYAML(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		if value[0] == '"' {
+			if s, err := strconv.Unquote(value); err == nil {
+				return s
+			}
+		}
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// apply sets every flag named in values that was not given explicitly on
+// the command line, so CLI flags always win. Unknown keys are reported.
+func (values settings) apply(cliSet map[string]bool, t *target) error {
+	var unknown []string
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		switch key {
+		case "source":
+			if len(t.sources) > 0 {
+				continue
+			}
+			items, isList := value.([]interface{})
+			if !isList {
+				items = []interface{}{value}
+			}
+			for _, item := range items {
+				str, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("%s: expected a string or a list of strings", key)
+				}
+				t.sources = append(t.sources, str)
+			}
+			continue
+		case "zone":
+			str, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%s: expected a string", key)
+			}
+			if t.zone == "" {
+				t.zone = str
+			}
+			continue
+		}
+
+		f := flag.Lookup(key)
+		if f == nil {
+			unknown = append(unknown, key)
+			continue
+		}
+		if cliSet[key] {
+			continue
+		}
+		items, isList := value.([]interface{})
+		if !isList {
+			items = []interface{}{value}
+		}
+		strs := make([]string, 0, len(items))
+		for _, item := range items {
+			str, err := settingString(item)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			strs = append(strs, str)
+		}
+		// Repeatable flags take the items one by one, so an item holding a
+		// comma, like a Cache-Control header, stays whole. Other flags read
+		// a list as their comma-separated value.
+		switch f.Value.(type) {
+		case *stringList, *valueList:
+		default:
+			strs = []string{strings.Join(strs, ",")}
+		}
+		// flag.Set marks the flag as set, so checks such as flagSet treat
+		// a value from a file like one given on the command line.
+		for _, str := range strs {
+			if err := flag.Set(key, str); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func settingString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+func cliFlags() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
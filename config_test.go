@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("ZONE", "my-zone")
+	t.Setenv("EMPTY", "")
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{"plain", "plain", ""},
+		{"$ZONE", "my-zone", ""},
+		{"${ZONE}-staging", "my-zone-staging", ""},
+		{"$ZONE/sub", "my-zone/sub", ""},
+		{"price: 5$", "price: 5$", ""},
+		{"a $ b", "a $ b", ""},
+		{"$1.00", "$1.00", ""},
+		{"$$ZONE", "$ZONE", ""},
+		{"${EMPTY:-fallback}", "fallback", ""},
+		{"${UNSET_FOR_TEST:-fallback}", "fallback", ""},
+		{"${ZONE:-fallback}", "my-zone", ""},
+		{"${EMPTY}", "", ""},
+		{"${UNSET_FOR_TEST}", "", "UNSET_FOR_TEST is not set"},
+		{"$UNSET_FOR_TEST", "", "UNSET_FOR_TEST is not set"},
+		{"${ZONE", "", "unterminated ${"},
+		{"${}", "", "invalid variable name"},
+		{"${1BAD}", "", "invalid variable name"},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expandEnv(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigExpandsListItems(t *testing.T) {
+	t.Setenv("SITE", "public")
+	t.Setenv("EMPTY", "")
+	dir := t.TempDir()
+	file := writeFile(t, filepath.Join(dir, "bunny-sync.yaml"), strings.Join([]string{
+		"zone: ${SITE}-zone",
+		"source:",
+		"  - ./$SITE",
+		"  - ${EMPTY:-./extra}",
+		"exclude: [\"*.$SITE\", drafts/]",
+		"concurrency: 4",
+	}, "\n"))
+
+	values, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["zone"] != "public-zone" {
+		t.Errorf("zone = %v", values["zone"])
+	}
+	sources, _ := values["source"].([]interface{})
+	if len(sources) != 2 || sources[0] != "./public" || sources[1] != "./extra" {
+		t.Errorf("source = %v", values["source"])
+	}
+	exclude, _ := values["exclude"].([]interface{})
+	if len(exclude) != 2 || exclude[0] != "*.public" || exclude[1] != "drafts/" {
+		t.Errorf("exclude = %v", values["exclude"])
+	}
+
+	bad := writeFile(t, filepath.Join(dir, "bad.yaml"), "include:\n  - ok\n  - ${UNSET_FOR_TEST}\n")
+	if _, err := loadConfig(bad); err == nil || !strings.Contains(err.Error(), "include") {
+		t.Errorf("got %v, want an error naming the include setting", err)
+	}
+}
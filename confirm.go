@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const deleteSampleSize = 10
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmDeletes shows the planned deletes on stderr, so the prompt never
+// mixes with --output json on stdout, and waits for a typed "yes".
+func confirmDeletes(side string, paths []string) bool {
+	fmt.Fprintf(os.Stderr, "About to delete %d %s files:\n", len(paths), side)
+	for i, p := range paths {
+		if i == deleteSampleSize {
+			fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(paths)-deleteSampleSize)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "  %s\n", p)
+	}
+	fmt.Fprint(os.Stderr, "Type 'yes' to proceed: ")
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/veter2005/bunny-storage-sync/api"
 	"github.com/veter2005/bunny-storage-sync/syncer"
@@ -11,19 +18,133 @@ import (
 
 const version = "1.2.2"
 
+// Exit codes, also listed in --help.
+const (
+	exitOK          = 0
+	exitFatal       = 1
+	exitPartial     = 2
+	exitNothingToDo = 3
+	exitInterrupted = 130
+)
+
+const exitCodesHelp = `
+Exit codes:
+  0  sync completed without errors
+  1  fatal or configuration error, the sync did not complete
+  2  sync completed but some files failed
+  3  nothing to do (only with --fail-on-empty)
+  130  interrupted by Ctrl-C or SIGTERM
+`
+
 func main() {
-	var dryRun, sizeOnly, onlyMissing, deleteRemote, verbose, showVersion bool
-	var concurrency int
-	var syncPath string
+	var dryRun, sizeOnly, onlyMissing, probeMissing, noOverwriteNewer, skipSpaceCheck, deleteRemote, deleteAfterVerify, sorted, verbose, quiet, continueOnError, showVersion bool
+	var generateIndex, generateSitemap, mirror, allowEmptySource, failOnEmpty, assumeYes, noDefaultExcludes, includeHidden, followSymlinks, compress, circuitBreaker, forceHTTP1, verifyContentLength, writeSyncMarker, verifyViaRelist, verifyUploads, atomic, pruneOnly, pruneEmptyDirs, useManifest, fullList, detectDrift, trustCache, purge bool
+	var maxDeletePercent, circuitThreshold, rateLimit float64
+	var progressInterval, checkpointInterval, circuitCooldown, retryDelay, timeout, deadline time.Duration
+	var concurrency, listConcurrency, uploadConcurrency, downloadConcurrency, deleteConcurrency, maxObjects, maxErrors, retries int
+	var syncPath, logLevel, retryLogPath, allowedContentTypes, deleteCheckpoint, checkpointPath, gitDiff, mapFile, stripPrefix, baseURL string
+	var include, exclude, includeFrom, excludeFrom, private stringList
+	var contentTypes, dispositions, headers valueList
+	var direction, cacheFile, checksumAlgo, region, endpoint, pullZoneHostname, output, reportFile, apiKeyFile string
+	var extensions, configFile, profileName, profilesFile, onDuplicate, sanitizeNames, sanitizeMap, transferBudget, maxFileSize, since string
 
 	flag.BoolVar(&dryRun, "dry-run", false, "Show what would be done")
+	flag.StringVar(&direction, "direction", syncer.DirectionPush, "push uploads local files to the zone, pull downloads the zone into the local directory")
+	flag.BoolVar(&skipSpaceCheck, "skip-space-check", false, "Pull even when the planned downloads do not fit in the free local disk space")
+	flag.StringVar(&output, "output", "text", "Summary format: text, or json for a machine-readable plan and summary on stdout")
+	flag.StringVar(&reportFile, "report-file", "", "Also write a report of the run, in the --output format, to this file, even when the sync fails")
 	flag.BoolVar(&sizeOnly, "size-only", false, "Fast comparison by size")
+	flag.StringVar(&checksumAlgo, "checksum-algo", syncer.ChecksumSHA256, "How files are compared: sha256 of the stored bytes, as the zone lists it, or none to compare sizes and upload without a checksum")
 	flag.BoolVar(&onlyMissing, "only-missing", false, "Only upload new files")
+	flag.BoolVar(&probeMissing, "probe-missing", false, "With --only-missing, look up each local file in the zone instead of listing the whole zone")
+	flag.BoolVar(&noOverwriteNewer, "no-overwrite-newer", false, "Do not update files whose zone copy changed after the local file; list them as conflicts")
 	flag.BoolVar(&deleteRemote, "delete", false, "Delete remote files not in local")
+	flag.BoolVar(&deleteAfterVerify, "delete-after-verify", false, "Skip the delete phase if any upload failed or, with --verify-via-relist, did not verify")
 	flag.IntVar(&concurrency, "concurrency", 10, "Parallel operations")
-	flag.BoolVar(&verbose, "verbose", false, "Enable debug logging")
+	flag.BoolVar(&sorted, "sorted", false, "Start operations in path order (shallower first, then by name) for reproducible plans and logs")
+	flag.IntVar(&listConcurrency, "list-concurrency", 0, "Parallel directory listings (0 = --concurrency)")
+	flag.IntVar(&uploadConcurrency, "upload-concurrency", 0, "Parallel uploads (0 = --concurrency)")
+	flag.IntVar(&downloadConcurrency, "download-concurrency", 0, "Parallel downloads in pull mode (0 = --concurrency)")
+	flag.IntVar(&deleteConcurrency, "delete-concurrency", 0, "Parallel deletes (0 = --concurrency)")
+	flag.BoolVar(&verbose, "verbose", false, "Enable debug logging (same as --log-level debug)")
+	flag.BoolVar(&quiet, "quiet", false, "Log only errors and the final summary (same as --log-level error)")
+	flag.StringVar(&logLevel, "log-level", "", "Log messages at this level or more severe: error, warn, info or debug (default info)")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.StringVar(&syncPath, "path", "", "Subdirectory in zone")
+	flag.BoolVar(&generateIndex, "generate-index", false, "Generate and upload an index.json listing for every directory")
+	flag.BoolVar(&generateSitemap, "generate-sitemap", false, "Generate and upload sitemap.xml for the synced HTML files (requires --base-url)")
+	flag.StringVar(&baseURL, "base-url", "", "Public site URL used in generated sitemap entries")
+	flag.StringVar(&allowedContentTypes, "allowed-content-types", "", "Comma-separated MIME types or globs (e.g. text/*,image/png) permitted for upload")
+	flag.StringVar(&deleteCheckpoint, "delete-checkpoint", "", "Journal delete progress to this file so an interrupted --delete phase can resume")
+	flag.StringVar(&since, "since", "", "Only consider files modified after this RFC3339 time or this long ago (e.g. 2h); older files are neither uploaded nor deleted")
+	flag.StringVar(&maxFileSize, "max-file-size", "", "Skip local files larger than this (e.g. 100MB) and keep their remote copies")
+	flag.IntVar(&maxObjects, "max-objects", 0, "Abort before uploading if the source has more than this many files (0 = unlimited)")
+	flag.BoolVar(&mirror, "mirror", false, "Make the remote match local exactly (--delete with safety defaults)")
+	flag.Float64Var(&maxDeletePercent, "max-delete-percent", 0, "Refuse to delete more than this percentage of remote files (0 = no limit, --mirror default 50)")
+	flag.BoolVar(&allowEmptySource, "allow-empty-source", false, "With --mirror, allow deleting everything when the source is empty")
+	flag.BoolVar(&failOnEmpty, "fail-on-empty", false, "Exit with code 3 when the run had nothing to upload, download or delete")
+	flag.BoolVar(&pruneOnly, "prune-only", false, "Only delete remote files missing locally; skip comparison and uploads entirely")
+	flag.BoolVar(&pruneEmptyDirs, "prune-empty-dirs", false, "After the delete phase, remove remote directories the sync left empty (with --delete also ones that were empty already)")
+	flag.BoolVar(&assumeYes, "yes", false, "Do not ask for confirmation before deleting; required for deletes when stdin is not a terminal")
+	flag.BoolVar(&assumeYes, "force", false, "Alias for --yes")
+	flag.DurationVar(&progressInterval, "progress-interval", 0, "Log upload progress with throughput and ETA at this interval (e.g. 30s)")
+	flag.StringVar(&cacheFile, "cache-file", "", "Remember file checksums by path, size and mtime in this file to skip re-hashing unchanged files")
+	flag.StringVar(&checkpointPath, "checkpoint", "", "State file recording completed uploads so a restarted sync skips them")
+	flag.DurationVar(&checkpointInterval, "checkpoint-interval", time.Minute, "How often to persist the --checkpoint file during the upload phase")
+	flag.Var(&include, "include", "Only sync files matching this glob (repeatable or comma-separated, ** matches any depth)")
+	flag.Var(&exclude, "exclude", "Never sync or delete files matching this glob (repeatable or comma-separated, wins over --include)")
+	flag.Var(&includeFrom, "include-from", "Read --include patterns from this file, one per line (repeatable)")
+	flag.Var(&excludeFrom, "exclude-from", "Read --exclude patterns from this file, one per line (repeatable)")
+	flag.Var(&private, "private", "Refuse to upload files matching this glob, which must not be public; Bunny Storage has no per-object access control (repeatable)")
+	flag.StringVar(&extensions, "ext", "", "Only sync files with these comma-separated extensions (e.g. html,css,js,png); applied before --include/--exclude")
+	flag.Var(&contentTypes, "content-type", "Force the Content-Type of matching uploads, as glob=type (e.g. *.wasm=application/wasm, repeatable)")
+	flag.Var(&dispositions, "content-disposition", "Set Content-Disposition on matching uploads, as glob:attachment or glob:inline, with the file name taken from the object (e.g. \"*.zip:attachment\", repeatable)")
+	flag.Var(&headers, "header", "Send a header with uploads, as \"Name: value\", or glob=Name: value for matching files only (e.g. \"*.html=Cache-Control: no-cache\", repeatable)")
+	flag.BoolVar(&noDefaultExcludes, "no-default-excludes", false, "Also sync OS/editor junk files (.DS_Store, Thumbs.db, *.swp, ...)")
+	flag.BoolVar(&includeHidden, "include-hidden", false, "Also sync dotfiles and dot-directories such as .git and .env (.well-known is always synced)")
+	flag.BoolVar(&compress, "compress", false, "Store HTML, CSS, JS, JSON, SVG and other text assets gzip-compressed when that makes them smaller")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", false, "Sync the targets of symlinked files and directories instead of skipping them")
+	flag.StringVar(&gitDiff, "git-diff", "", "Only sync files changed between two git refs (<base>..<head>), skipping the full walk")
+	flag.BoolVar(&circuitBreaker, "circuit-breaker", false, "Pause operations while the recent error rate is too high")
+	flag.Float64Var(&circuitThreshold, "circuit-threshold", 0.5, "Error rate over the last 20 operations that trips the circuit breaker")
+	flag.DurationVar(&circuitCooldown, "circuit-cooldown", 30*time.Second, "How long the circuit breaker pauses before probing again")
+	flag.StringVar(&mapFile, "map-file", "", "JSON or CSV file remapping local relative paths to remote paths")
+	flag.StringVar(&stripPrefix, "strip-prefix", "", "Only sync files below this source subdirectory (e.g. dist/) and drop it from their remote paths")
+	flag.StringVar(&apiKeyFile, "api-key-file", "", "Read the storage zone password from this file (- for stdin) instead of BCDN_APIKEY")
+	flag.StringVar(&region, "region", "", "Storage region code of the zone (de, uk, se, ny, la, sg, syd, br, jh); default is the main endpoint")
+	flag.StringVar(&endpoint, "endpoint", "", "Full storage endpoint URL, overriding --region")
+	flag.Float64Var(&rateLimit, "rate-limit", 0, "Maximum storage API requests per second across all workers (0 = unlimited)")
+	flag.BoolVar(&forceHTTP1, "force-http1", false, "Disable HTTP/2 and use HTTP/1.1 connections only")
+	flag.BoolVar(&verifyContentLength, "verify-content-length", false, "Fail uploads whose response reports a stored size different from the bytes sent")
+	flag.StringVar(&onDuplicate, "on-duplicate", syncer.DuplicateError, "When two local files map to one remote path: error or last-wins")
+	flag.BoolVar(&writeSyncMarker, "write-sync-marker", false, "Upload .last-sync.json with run metadata to the zone root after a successful sync")
+	flag.StringVar(&sanitizeNames, "sanitize-names", syncer.SanitizeWarn, "Policy for names Windows cannot store: warn, error, skip or rewrite")
+	flag.StringVar(&sanitizeMap, "sanitize-map", "", "With --sanitize-names rewrite, write a JSON map of rewritten remote paths to original names")
+	flag.BoolVar(&verifyViaRelist, "verify-via-relist", false, "After uploading, re-list the changed prefix once and check every upload's checksum")
+	flag.BoolVar(&verifyUploads, "verify", false, "Read back each upload's checksum and size from the API and upload once more on a mismatch (one extra request per file)")
+	flag.BoolVar(&atomic, "atomic", false, "Upload changes to a staging directory first and only update live paths once all of them are stored (uploads everything twice)")
+	flag.StringVar(&transferBudget, "transfer-budget", "", "Stop starting new uploads once this many bytes were sent in this run (e.g. 10GB)")
+	flag.BoolVar(&useManifest, "manifest", false, "Compare against "+syncer.ManifestName+" kept in the zone instead of listing it, and rewrite it after the sync")
+	flag.BoolVar(&fullList, "full", false, "With --manifest, list the zone anyway and rebuild the manifest from the listing")
+	flag.BoolVar(&trustCache, "trust-cache", false, "Skip reading files whose size and mtime match the zone manifest's record of their last upload while the listed checksum still matches it")
+	flag.BoolVar(&detectDrift, "detect-drift", false, "With --dry-run, compare every file by checksum and report files changed only in the zone as drift")
+	flag.IntVar(&retries, "retries", 2, "Retries for requests failing with 429, 5xx or a network error (0 = no retries)")
+	flag.DurationVar(&retryDelay, "retry-delay", 500*time.Millisecond, "Base delay before the first retry; doubled for each further retry, plus jitter")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "Give up on a request attempt that makes no progress for this long: connecting, waiting for the response or transferring a body (0 = none)")
+	flag.DurationVar(&deadline, "deadline", 0, "Time limit for the whole sync; when it passes, outstanding work is cancelled and the run fails (0 = none)")
+	flag.BoolVar(&continueOnError, "continue-on-error", true, "Keep syncing other files after one fails; =false cancels the remaining work on the first failure")
+	flag.IntVar(&maxErrors, "max-errors", 0, "Cancel the remaining work once this many files have failed (0 = unlimited)")
+	flag.BoolVar(&purge, "purge", false, "Purge uploaded and deleted files from the CDN cache after the sync (needs BUNNY_API_KEY)")
+	flag.StringVar(&pullZoneHostname, "pull-zone-hostname", "", "Hostname the pull zone serves this storage zone under, used to build purge URLs")
+	flag.StringVar(&retryLogPath, "retry-log", "", "Append a JSON record for every retry attempt to this file")
+	flag.StringVar(&configFile, "config", "", "Load flag defaults and the source and zone from this YAML or JSON file (default "+defaultConfigFile+" if present)")
+	flag.StringVar(&profileName, "profile", "", "Load zone, path and flag defaults from this named profile")
+	flag.StringVar(&profilesFile, "profiles-file", defaultProfilesFile, "JSON file containing named profiles")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <source>... <zone>\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), exitCodesHelp)
+	}
 	flag.Parse()
 
 	if showVersion {
@@ -31,21 +152,169 @@ func main() {
 		os.Exit(0)
 	}
 
-	if flag.NArg() < 2 {
+	t := newTarget(flag.Args())
+	cliSet := cliFlags()
+	if configFile == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			configFile = defaultConfigFile
+		}
+	}
+	if configFile != "" {
+		config, err := loadConfig(configFile)
+		if err == nil {
+			err = config.apply(cliSet, &t)
+		}
+		if err != nil {
+			fmt.Printf("Error: config %s: %v\n", configFile, err)
+			os.Exit(1)
+		}
+	}
+	if profileName != "" {
+		profile, err := loadProfile(profilesFile, profileName)
+		if err == nil {
+			err = profile.apply(cliSet, &t)
+		}
+		if err != nil {
+			fmt.Printf("Error: profile %s: %v\n", profileName, err)
+			os.Exit(1)
+		}
+	}
+
+	if pruneOnly {
+		deleteRemote = true
+	}
+	if mirror {
+		deleteRemote = true
+		if !flagSet("max-delete-percent") {
+			maxDeletePercent = 50
+		}
+	}
+
+	if len(t.sources) == 0 || t.zone == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	if output != "text" && output != "json" {
+		fmt.Printf("Error: --output must be text or json, got %q\n", output)
+		os.Exit(1)
+	}
+
+	bunnyAPIKey := os.Getenv("BUNNY_API_KEY")
+	if purge && (bunnyAPIKey == "" || pullZoneHostname == "") {
+		fmt.Println("Error: --purge needs BUNNY_API_KEY and --pull-zone-hostname")
+		os.Exit(1)
+	}
+
+	level, err := resolveLogLevel(logLevel, verbose, quiet)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	verbose = level >= api.LevelDebug
+	logger := api.LevelLogger{Logger: api.StdLogger{}, Level: level}
+
+	budget, err := parseSize(transferBudget)
+	if err != nil {
+		fmt.Printf("Error: --transfer-budget: %v\n", err)
+		os.Exit(1)
+	}
+	fileSizeLimit, err := parseSize(maxFileSize)
+	if err != nil {
+		fmt.Printf("Error: --max-file-size: %v\n", err)
+		os.Exit(1)
+	}
+
+	sinceTime, err := parseSince(since, time.Now())
+	if err != nil {
+		fmt.Printf("Error: --since: %v\n", err)
+		os.Exit(1)
+	}
+
+	filePatterns, err := readPatternFiles(includeFrom)
+	if err != nil {
+		fmt.Printf("Error: --include-from: %v\n", err)
+		os.Exit(1)
+	}
+	include = append(include, filePatterns...)
+	if filePatterns, err = readPatternFiles(excludeFrom); err != nil {
+		fmt.Printf("Error: --exclude-from: %v\n", err)
+		os.Exit(1)
+	}
+	exclude = append(exclude, filePatterns...)
+
+	typeRules, err := parseContentTypes(contentTypes)
+	if err != nil {
+		fmt.Printf("Error: --content-type: %v\n", err)
+		os.Exit(1)
+	}
+	dispositionRules, err := parseDispositions(dispositions)
+	if err != nil {
+		fmt.Printf("Error: --content-disposition: %v\n", err)
+		os.Exit(1)
+	}
+	uploadHeaders, headerRules, err := parseHeaders(headers)
+	if err != nil {
+		fmt.Printf("Error: --header: %v\n", err)
+		os.Exit(1)
+	}
+
+	if endpoint == "" {
+		if _, err := api.RegionEndpoint(region); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	apiKey := os.Getenv("BCDN_APIKEY")
+	if apiKeyFile != "" {
+		if apiKey, err = readAPIKey(apiKeyFile); err != nil {
+			fmt.Printf("Error: --api-key-file: %v\n", err)
+			os.Exit(1)
+		}
+	}
 	if apiKey == "" {
-		fmt.Println("Error: BCDN_APIKEY not set")
+		fmt.Println("Error: BCDN_APIKEY not set (or use --api-key-file)")
 		os.Exit(1)
 	}
 
 	storage := api.BCDNStorage{
-		ZoneName: flag.Arg(1),
+		ZoneName: t.zone,
 		APIKey:   apiKey,
-		Verbose:  verbose, 
+		Verbose:  verbose,
+		Logger:   logger,
+
+		Region:   region,
+		Endpoint: endpoint,
+
+		BunnyAPIKey: bunnyAPIKey,
+
+		MaxRetries: retries,
+		RetryDelay: retryDelay,
+		Timeout:    timeout,
+
+		ForceHTTP1:          forceHTTP1,
+		VerifyContentLength: verifyContentLength,
+		Limiter:             api.NewRateLimiter(rateLimit),
+
+		ContentTypes:  typeRules,
+		UploadHeaders: uploadHeaders,
+		HeaderRules:   headerRules,
+		Dispositions:  dispositionRules,
+	}
+
+	if retries == 0 {
+		storage.MaxRetries = -1
+	}
+
+	if retryLogPath != "" {
+		f, err := os.OpenFile(retryLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Printf("Error: cannot open retry log: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		storage.RetryLog = api.NewRetryLog(f)
 	}
 
 	syncerService := syncer.BCDNSyncer{
@@ -56,10 +325,335 @@ func main() {
 		Delete:      deleteRemote,
 		Concurrency: concurrency,
 		Verbose:     verbose,
+		Logger:      logger,
+
+		DeleteAfterVerify: deleteAfterVerify,
+		Sorted:            sorted,
+		ProbeMissing:      probeMissing,
+		NoOverwriteNewer:  noOverwriteNewer,
+
+		ListConcurrency:     listConcurrency,
+		UploadConcurrency:   uploadConcurrency,
+		DownloadConcurrency: downloadConcurrency,
+		DeleteConcurrency:   deleteConcurrency,
+
+		GenerateIndex:       generateIndex,
+		GenerateSitemap:     generateSitemap,
+		BaseURL:             baseURL,
+		AllowedContentTypes: splitList(allowedContentTypes),
+		Private:             private,
+		DeleteCheckpoint:    deleteCheckpoint,
+		MaxObjects:          maxObjects,
+		MaxFileSize:         fileSizeLimit,
+		Since:               sinceTime,
+
+		RefuseEmptySource: (mirror || pruneOnly) && !allowEmptySource,
+		MaxDeletePercent:  maxDeletePercent,
+
+		ProgressInterval: progressInterval,
+		Deadline:         deadline,
+		FailFast:         !continueOnError,
+		MaxErrors:        maxErrors,
+
+		Checkpoint:         checkpointPath,
+		CheckpointInterval: checkpointInterval,
+
+		NoDefaultExcludes: noDefaultExcludes,
+		IncludeHidden:     includeHidden,
+		FollowSymlinks:    followSymlinks,
+		Compress:          compress,
+		GitDiff:           gitDiff,
+		Include:           include,
+		Exclude:           exclude,
+		Extensions:        splitList(extensions),
+		ChecksumCache:     cacheFile,
+		ChecksumAlgo:      checksumAlgo,
+		OnDuplicate:       onDuplicate,
+		StripPrefix:       stripPrefix,
+
+		Purge:            purge,
+		PullZoneHostname: pullZoneHostname,
+
+		SanitizeNames:   sanitizeNames,
+		SanitizeMapFile: sanitizeMap,
+
+		VerifyViaRelist: verifyViaRelist,
+		VerifyUploads:   verifyUploads,
+		TransferBudget:  budget,
+		Atomic:          atomic,
+
+		WriteSyncMarker: writeSyncMarker,
+		Version:         version,
+
+		PruneOnly:      pruneOnly,
+		PruneEmptyDirs: pruneEmptyDirs,
+		Direction:      direction,
+		SkipSpaceCheck: skipSpaceCheck,
+
+		UseManifest: useManifest,
+		FullList:    fullList,
+		DetectDrift: detectDrift,
+		TrustCache:  trustCache,
+
+		CircuitBreaker:   circuitBreaker,
+		CircuitThreshold: circuitThreshold,
+		CircuitCooldown:  circuitCooldown,
+	}
+	if output == "json" {
+		syncerService.JSONOutput = os.Stdout
+	}
+	if mapFile != "" {
+		pathMap, err := syncer.LoadPathMap(mapFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		syncerService.PathMap = pathMap
 	}
+	if deleteRemote && !assumeYes && !dryRun {
+		if !isTerminal(os.Stdin) {
+			fmt.Println("Error: deleting needs confirmation; pass --yes when not running in a terminal")
+			os.Exit(1)
+		}
+		side := "remote"
+		if direction == syncer.DirectionPull {
+			side = "local"
+		}
+		syncerService.ConfirmDeletes = func(paths []string) bool { return confirmDeletes(side, paths) }
+	}
+
+	syncerService.Interrupt = interruptOnSignal()
 
-	if err := syncerService.Sync(flag.Arg(0), syncPath); err != nil {
+	result, err := syncerService.Run(context.Background(), t.sources, syncPath)
+	if reportFile != "" {
+		r := newRunReport(result, err, t.zone, syncPath, direction, dryRun)
+		if werr := writeReportFile(reportFile, output, r); werr != nil {
+			fmt.Printf("Error: --report-file: %v\n", werr)
+		}
+	}
+	if errors.Is(err, syncer.ErrInterrupted) {
+		fmt.Println("Sync interrupted")
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
 		fmt.Printf("Sync failed: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
+	}
+	os.Exit(exitCode(result, failOnEmpty))
+}
+
+// interruptOnSignal returns a channel closed on the first SIGINT or SIGTERM,
+// which lets transfers in flight finish; a second signal exits at once.
+func interruptOnSignal() <-chan struct{} {
+	interrupt := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Println("Interrupted, finishing transfers in progress (press Ctrl-C again to exit now)")
+		close(interrupt)
+		<-signals
+		fmt.Println("Interrupted again, exiting")
+		os.Exit(exitInterrupted)
+	}()
+	return interrupt
+}
+
+// exitCode picks the exit status of a run that completed.
+func exitCode(result *syncer.SyncResult, failOnEmpty bool) int {
+	if n := len(result.Errors); n > 0 {
+		fmt.Printf("Sync completed with %d failed files\n", n)
+		return exitPartial
+	}
+	changed := len(result.Uploaded) + len(result.Updated) + len(result.Downloaded) + len(result.Deleted)
+	if failOnEmpty && changed == 0 {
+		fmt.Println("Nothing to do")
+		return exitNothingToDo
+	}
+	return exitOK
+}
+
+// resolveLogLevel applies --log-level, which supersedes --verbose; --quiet
+// is --log-level error.
+func resolveLogLevel(name string, verbose, quiet bool) (api.Level, error) {
+	if name != "" {
+		level, err := api.ParseLevel(name)
+		if err != nil {
+			return 0, fmt.Errorf("--log-level: %w", err)
+		}
+		if quiet && level != api.LevelError {
+			return 0, fmt.Errorf("--quiet conflicts with --log-level %s", level)
+		}
+		return level, nil
+	}
+	switch {
+	case quiet && verbose:
+		return 0, fmt.Errorf("--quiet conflicts with --verbose")
+	case quiet:
+		return api.LevelError, nil
+	case verbose:
+		return api.LevelDebug, nil
+	}
+	return api.LevelInfo, nil
+}
+
+// readAPIKey reads a key from a file or, for "-", from stdin, as mounted
+// secrets usually are; surrounding whitespace and newlines are dropped.
+func readAPIKey(name string) (string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("%s is empty", name)
+	}
+	return key, nil
+}
+
+// parseSince accepts an RFC3339 timestamp or a duration counted back from now.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid value %q, expected an RFC3339 time or a duration such as 2h", value)
+	}
+	return now.Add(-d), nil
+}
+
+// readPatternFiles reads one pattern per line from each file, skipping blank
+// lines and # comments. Lines are not split at commas.
+func readPatternFiles(names []string) ([]string, error) {
+	var patterns []string
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+	return patterns, nil
+}
+
+func parseContentTypes(values []string) ([]api.ContentTypeRule, error) {
+	var rules []api.ContentTypeRule
+	for _, v := range values {
+		pattern, contentType, ok := strings.Cut(v, "=")
+		pattern, contentType = strings.TrimSpace(pattern), strings.TrimSpace(contentType)
+		if !ok || pattern == "" || contentType == "" {
+			return nil, fmt.Errorf("invalid rule %q, expected glob=type", v)
+		}
+		rules = append(rules, api.ContentTypeRule{Pattern: pattern, ContentType: contentType})
+	}
+	return rules, nil
+}
+
+// parseDispositions reads --content-disposition rules. The disposition type
+// holds no colon, so the last one separates it from the glob.
+func parseDispositions(values []string) ([]api.DispositionRule, error) {
+	var rules []api.DispositionRule
+	for _, v := range values {
+		i := strings.LastIndex(v, ":")
+		if i < 0 || strings.TrimSpace(v[:i]) == "" {
+			return nil, fmt.Errorf("invalid rule %q, expected glob:attachment or glob:inline", v)
+		}
+		pattern, disposition := strings.TrimSpace(v[:i]), strings.TrimSpace(v[i+1:])
+		if err := api.ValidateDisposition(disposition); err != nil {
+			return nil, fmt.Errorf("%q: %w", v, err)
+		}
+		rules = append(rules, api.DispositionRule{Pattern: pattern, Disposition: disposition})
+	}
+	return rules, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// stringList is a repeatable flag; each value may also hold a comma-separated
+// list.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, splitList(value)...)
+	return nil
+}
+
+// valueList is a repeatable flag whose values are kept whole, for values
+// such as headers that may contain commas themselves.
+type valueList []string
+
+func (l *valueList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *valueList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// parseHeaders splits --header values into headers for every upload and
+// glob-scoped rules. Header names cannot contain "=", so one before the colon
+// separates the glob.
+func parseHeaders(values []string) (map[string]string, []api.HeaderRule, error) {
+	all := map[string]string{}
+	var rules []api.HeaderRule
+	for _, v := range values {
+		field, value, ok := strings.Cut(v, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid header %q, expected Name: value", v)
+		}
+		pattern, name, scoped := strings.Cut(field, "=")
+		if !scoped {
+			pattern, name = "", field
+		}
+		pattern, name, value = strings.TrimSpace(pattern), strings.TrimSpace(name), strings.TrimSpace(value)
+		if scoped && pattern == "" {
+			return nil, nil, fmt.Errorf("invalid header %q, empty glob", v)
+		}
+		if err := api.ValidateHeader(name, value); err != nil {
+			return nil, nil, fmt.Errorf("%q: %w", v, err)
+		}
+		if scoped {
+			rules = append(rules, api.HeaderRule{Pattern: pattern, Name: name, Value: value})
+		} else {
+			all[name] = value
+		}
 	}
+	return all, rules, nil
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+	"github.com/veter2005/bunny-storage-sync/syncer"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReadPatternFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := writeFile(t, filepath.Join(dir, "first"), "# build output\n*.map\n\n  drafts/  \r\n{a,b}.txt\n")
+	second := writeFile(t, filepath.Join(dir, "second"), "docs/**/*.tmp")
+
+	got, err := readPatternFiles([]string{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"*.map", "drafts/", "{a,b}.txt", "docs/**/*.tmp"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := readPatternFiles([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("missing pattern file was not reported")
+	}
+}
+
+// Patterns from a file add to the ones given on the command line, as main
+// combines them.
+func TestPatternFileWithInlinePattern(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	for _, name := range []string{"index.html", "app.js", "app.js.map", "drafts/post.html", "notes.txt"} {
+		writeFile(t, filepath.Join(src, name), name)
+	}
+	patterns := writeFile(t, filepath.Join(dir, "exclude.txt"), "# not deployed\n*.map\ndrafts/\n")
+
+	exclude := []string{"*.txt"}
+	filePatterns, err := readPatternFiles([]string{patterns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude = append(exclude, filePatterns...)
+
+	s := syncer.BCDNSyncer{
+		API:     api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+		Exclude: exclude,
+		Logger:  api.LevelLogger{Logger: api.StdLogger{}, Level: api.LevelError},
+	}
+	plan, err := s.Plan(src, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, op := range plan.Operations {
+		got = append(got, op.Path)
+	}
+	sort.Strings(got)
+	if want := []string{"app.js", "index.html"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("planned %v, want %v", got, want)
+	}
+}
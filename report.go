@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/syncer"
+)
+
+// runReport is what --report-file records about a finished run.
+type runReport struct {
+	Time      time.Time      `json:"time"`
+	Zone      string         `json:"zone"`
+	Path      string         `json:"path"`
+	Direction string         `json:"direction"`
+	DryRun    bool           `json:"dryRun"`
+	Error     string         `json:"error,omitempty"`
+	Summary   syncer.Summary `json:"summary"`
+
+	Uploaded   []string          `json:"uploaded,omitempty"`
+	Updated    []string          `json:"updated,omitempty"`
+	Downloaded []string          `json:"downloaded,omitempty"`
+	Deleted    []string          `json:"deleted,omitempty"`
+	Errors     []reportError     `json:"errors,omitempty"`
+	Conflicts  []syncer.Conflict `json:"conflicts,omitempty"`
+}
+
+type reportError struct {
+	Op    string `json:"op"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error"`
+}
+
+func newRunReport(result *syncer.SyncResult, runErr error, zone, syncPath, direction string, dryRun bool) runReport {
+	r := runReport{
+		Time:       time.Now().UTC(),
+		Zone:       zone,
+		Path:       syncPath,
+		Direction:  direction,
+		DryRun:     dryRun,
+		Summary:    result.Summary,
+		Uploaded:   result.Uploaded,
+		Updated:    result.Updated,
+		Downloaded: result.Downloaded,
+		Deleted:    result.Deleted,
+		Conflicts:  result.Conflicts,
+	}
+	if runErr != nil {
+		r.Error = runErr.Error()
+	}
+	for _, e := range result.Errors {
+		r.Errors = append(r.Errors, reportError{Op: e.Op, Path: e.Path, Error: e.Err.Error()})
+	}
+	return r
+}
+
+// writeReportFile writes r to name as JSON or text, creating missing parent
+// directories.
+func writeReportFile(name, format string, r runReport) error {
+	var data []byte
+	if format == "json" {
+		var err error
+		if data, err = json.MarshalIndent(r, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	} else {
+		data = []byte(r.text())
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+func (r runReport) text() string {
+	var b strings.Builder
+	mode := r.Direction
+	if r.DryRun {
+		mode += " (dry run)"
+	}
+	fmt.Fprintf(&b, "Time: %s\n", r.Time.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Zone: %s\n", strings.TrimSuffix(r.Zone+"/"+r.Path, "/"))
+	fmt.Fprintf(&b, "Direction: %s\n", mode)
+	sum := r.Summary
+	fmt.Fprintf(&b, "Total: %d, New: %d, Updated: %d, Deleted: %d, Skipped: %d, Errors: %d\n",
+		sum.Total, sum.New, sum.Updated, sum.Deleted, sum.Skipped, sum.Errors)
+	fmt.Fprintf(&b, "Uploaded: %d bytes, Downloaded: %d bytes, Deleted: %d bytes\n", sum.BytesUploaded, sum.BytesDownloaded, sum.BytesDeleted)
+	fmt.Fprintf(&b, "Elapsed: %s\n", sum.Elapsed.Round(time.Millisecond))
+	if len(r.Errors) > 0 {
+		fmt.Fprintf(&b, "Failed files:\n")
+		for _, e := range r.Errors {
+			if e.Path == "" {
+				fmt.Fprintf(&b, "  %s: %s\n", e.Op, e.Error)
+			} else {
+				fmt.Fprintf(&b, "  %s %s: %s\n", e.Op, e.Path, e.Error)
+			}
+		}
+	}
+	if len(r.Conflicts) > 0 {
+		fmt.Fprintf(&b, "Not overwritten, changed in the zone:\n")
+		for _, c := range r.Conflicts {
+			fmt.Fprintf(&b, "  %s (zone %s, local %s)\n", c.Path, c.RemoteChanged.Format(time.RFC3339), c.LocalModified.Format(time.RFC3339))
+		}
+	}
+	if r.Error != "" {
+		fmt.Fprintf(&b, "Result: failed: %s\n", r.Error)
+	} else {
+		fmt.Fprintf(&b, "Result: completed\n")
+	}
+	return b.String()
+}
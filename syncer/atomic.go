@@ -0,0 +1,155 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+const stagingPrefix = ".staging-"
+
+// syncAtomic uploads every pending file to a staging directory first and only
+// touches the live paths once the whole set is stored and verified there.
+// Bunny Storage has no server-side move or copy, so promoting a staged file
+// means uploading it again from disk; atomic runs send their changes twice.
+// Promotion writes pages last, so a page never goes live before the assets
+// it references, and deletes only run after the caller gets a clean result.
+func (s *BCDNSyncer) syncAtomic(ctx context.Context, syncPath string, operations []operation, metrics *syncMetrics, cp *checkpoint) ([]operation, error) {
+	dir := path.Join(syncPath, stagingPrefix+time.Now().UTC().Format("20060102T150405"))
+	defer s.removeStaging(context.WithoutCancel(ctx), dir)
+
+	if err := s.stageUploads(ctx, dir, syncPath, operations, metrics); err != nil {
+		return nil, err
+	}
+
+	var uploaded []operation
+	for _, wave := range promotionWaves(operations) {
+		if len(wave) == 0 {
+			continue
+		}
+		errorsBefore := metrics.errors.Load()
+		done, err := s.processOperationsConcurrently(ctx, wave, metrics, cp)
+		uploaded = append(uploaded, done...)
+		if err != nil || ctx.Err() != nil {
+			return uploaded, err
+		}
+		failed := metrics.errors.Load() - errorsBefore
+		if failed > 0 {
+			return uploaded, fmt.Errorf("%d files failed to go live; later files and deletes were not applied", failed)
+		}
+	}
+	return uploaded, nil
+}
+
+// stageUploads stores every operation below dir and checks the staged copies
+// against a listing of dir. Any failure leaves the live paths untouched.
+func (s *BCDNSyncer) stageUploads(ctx context.Context, dir, syncPath string, operations []operation, metrics *syncMetrics) error {
+	s.logger().Infof("Staging %d uploads under %s", len(operations), dir)
+
+	failed := 0
+	var failedLock sync.Mutex
+	fail := func(op, relPath string, err error) {
+		s.logger().Errorf("staging %s: %v", relPath, err)
+		metrics.fail(op, relPath, err)
+		failedLock.Lock()
+		failed++
+		failedLock.Unlock()
+	}
+
+	sem := make(chan struct{}, s.workers(s.UploadConcurrency))
+	var wg sync.WaitGroup
+	for i := range operations {
+		wg.Add(1)
+		go func(o *operation) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			if o.checksum == "" {
+				checksum, err := s.fileChecksum(o.path, o.size, o.modTime)
+				if err != nil {
+					fail("read", o.relPath, err)
+					return
+				}
+				o.checksum = checksum
+			}
+			staged := *o
+			staged.relPath = stagedPath(dir, syncPath, o.relPath)
+			if s.breaker.acquire(ctx) != nil {
+				return
+			}
+			_, err := s.uploadFile(ctx, staged, o.checksum)
+			s.breaker.finish(ctx, err)
+			if err != nil {
+				fail("stage", o.relPath, err)
+			}
+		}(&operations[i])
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("staging interrupted: %w", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to stage; the live site was not changed", failed, len(operations))
+	}
+
+	objMap, err := s.fetchAllObjectsParallel(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("listing staged files failed: %w", err)
+	}
+	remote := make(map[string]api.BCDNObject, len(objMap))
+	for _, obj := range objMap {
+		remote[objectPath(s.API.ZoneName, obj)] = obj
+	}
+	for _, o := range operations {
+		obj, ok := remote[stagedPath(dir, syncPath, o.relPath)]
+		switch {
+		case !ok:
+			fail("stage", o.relPath, errors.New("staged copy is missing"))
+		case obj.Checksum != "" && !strings.EqualFold(obj.Checksum, o.checksum):
+			fail("stage", o.relPath, fmt.Errorf("staged copy has checksum %s, want %s", obj.Checksum, o.checksum))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d staged files did not verify; the live site was not changed", failed, len(operations))
+	}
+	return nil
+}
+
+// removeStaging deletes the staging directory and everything below it.
+func (s *BCDNSyncer) removeStaging(ctx context.Context, dir string) {
+	s.logDebug("Removing staging directory %s", dir)
+	if err := s.API.DeleteDirectoryContext(ctx, dir); err != nil && !errors.Is(err, api.ErrNotFound) {
+		s.logger().Errorf("removing staging directory %s: %v", dir, err)
+	}
+}
+
+func stagedPath(dir, syncPath, relPath string) string {
+	if syncPath != "" && strings.HasPrefix(relPath, syncPath+"/") {
+		relPath = strings.TrimPrefix(relPath, syncPath+"/")
+	}
+	return path.Join(dir, relPath)
+}
+
+// promotionWaves splits operations into assets and pages, in that order.
+func promotionWaves(operations []operation) [][]operation {
+	var assets, pages []operation
+	for _, o := range operations {
+		switch strings.ToLower(path.Ext(o.relPath)) {
+		case ".html", ".htm":
+			pages = append(pages, o)
+		default:
+			assets = append(assets, o)
+		}
+	}
+	return [][]operation{assets, pages}
+}
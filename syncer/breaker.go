@@ -0,0 +1,151 @@
+package syncer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+const (
+	breakerWindow       = 20
+	breakerPollInterval = 100 * time.Millisecond
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker pauses dispatch when the error rate over the last
+// breakerWindow operations exceeds threshold. After cooldown a single probe
+// operation is let through; its outcome closes or re-opens the circuit.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold float64
+	cooldown  time.Duration
+	state     breakerState
+	openedAt  time.Time
+	probing   bool
+	outcomes  [breakerWindow]bool
+	next      int
+	count     int
+	failures  int
+	log       api.Logger
+}
+
+func newCircuitBreaker(threshold float64, cooldown time.Duration, log api.Logger) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, log: log}
+}
+
+// acquire blocks until the circuit admits another operation, or returns the
+// context's error if ctx is done first.
+func (b *circuitBreaker) acquire(ctx context.Context) error {
+	if b == nil {
+		return ctx.Err()
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		wait := breakerPollInterval
+		switch b.state {
+		case breakerClosed:
+			b.mu.Unlock()
+			return nil
+		case breakerOpen:
+			remaining := b.cooldown - time.Since(b.openedAt)
+			if remaining <= 0 {
+				b.state = breakerHalfOpen
+				b.probing = true
+				b.mu.Unlock()
+				b.log.Infof("Circuit breaker half-open, sending probe request")
+				return nil
+			}
+			wait = remaining
+		case breakerHalfOpen:
+			if !b.probing {
+				b.probing = true
+				b.mu.Unlock()
+				return nil
+			}
+		}
+		b.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// finish reports the outcome of an operation admitted by acquire. An
+// operation cut short because ctx was cancelled says nothing about the
+// zone, so it is not recorded; it only hands back the probe slot.
+func (b *circuitBreaker) finish(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+	if ctx.Err() != nil {
+		b.mu.Lock()
+		if b.state == breakerHalfOpen {
+			b.probing = false
+		}
+		b.mu.Unlock()
+		return
+	}
+	b.record(err)
+}
+
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		if err != nil {
+			b.state = breakerOpen
+			b.openedAt = time.Now()
+			b.log.Infof("Circuit breaker probe failed, pausing for %s", b.cooldown)
+			return
+		}
+		b.state = breakerClosed
+		b.outcomes = [breakerWindow]bool{}
+		b.next, b.count, b.failures = 0, 0, 0
+		b.log.Infof("Circuit breaker closed, resuming operations")
+		return
+	}
+	if b.state == breakerOpen {
+		return
+	}
+
+	failed := err != nil
+	if b.count == breakerWindow {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+	} else {
+		b.count++
+	}
+	b.outcomes[b.next] = failed
+	b.next = (b.next + 1) % breakerWindow
+	if failed {
+		b.failures++
+	}
+
+	if b.count == breakerWindow && float64(b.failures)/float64(b.count) > b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.log.Infof("Circuit breaker open: %d of last %d operations failed, pausing for %s", b.failures, b.count, b.cooldown)
+	}
+}
@@ -0,0 +1,63 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerAcquireHonoursContext(t *testing.T) {
+	b := newCircuitBreaker(0.5, time.Hour, discardLogger{})
+	for range breakerWindow {
+		b.record(errors.New("boom"))
+	}
+	if b.state != breakerOpen {
+		t.Fatalf("state = %v, want open", b.state)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if err := b.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("acquire returned after %s, want it to stop on cancel", elapsed)
+	}
+}
+
+func TestBreakerIgnoresCancelledOperations(t *testing.T) {
+	b := newCircuitBreaker(0.5, time.Millisecond, discardLogger{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for range breakerWindow {
+		b.finish(ctx, context.Canceled)
+	}
+	if b.state != breakerClosed || b.count != 0 {
+		t.Fatalf("state = %v, count = %d; cancelled operations were recorded", b.state, b.count)
+	}
+
+	// A probe cut short by cancellation gives its slot back rather than
+	// leaving the circuit half-open with nobody probing.
+	for range breakerWindow {
+		b.record(errors.New("boom"))
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := b.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b.finish(ctx, context.Canceled)
+	if b.state != breakerHalfOpen || b.probing {
+		t.Fatalf("state = %v, probing = %v; want a free probe slot", b.state, b.probing)
+	}
+	probe, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	if err := b.acquire(probe); err != nil {
+		t.Fatalf("next probe not admitted: %v", err)
+	}
+	b.finish(probe, nil)
+	if b.state != breakerClosed {
+		t.Fatalf("state = %v, want closed after a good probe", b.state)
+	}
+}
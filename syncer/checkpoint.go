@@ -0,0 +1,148 @@
+package syncer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+type checkpointEntry struct {
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// checkpoint records uploads completed during a run. It is written
+// atomically (temp file + rename) so a crash mid-write leaves the previous
+// version intact.
+type checkpoint struct {
+	mu        sync.Mutex
+	writeMu   sync.Mutex
+	path      string
+	Completed map[string]checkpointEntry `json:"completed"`
+	dirty     bool
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{path: path, Completed: map[string]checkpointEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Completed == nil {
+		c.Completed = map[string]checkpointEntry{}
+	}
+	return c, nil
+}
+
+func (c *checkpoint) isDone(relPath string, info os.FileInfo) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.Completed[relPath]
+	return ok && e.Size == info.Size() && e.ModTime == info.ModTime().UnixNano()
+}
+
+func (c *checkpoint) done(o operation) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Completed[o.relPath] = checkpointEntry{Size: o.size, ModTime: o.modTime.UnixNano(), Checksum: o.checksum}
+	c.dirty = true
+}
+
+// flush writes the checkpoint if it changed. Flushes are serialized so an
+// older snapshot can never be renamed over a newer one.
+func (c *checkpoint) flush() error {
+	if c == nil {
+		return nil
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(c)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.path, data)
+}
+
+// finish removes the checkpoint once the sync it belongs to completed; an
+// incomplete sync keeps it for the next run.
+func (c *checkpoint) finish(completed bool) error {
+	if c == nil {
+		return nil
+	}
+	if !completed {
+		return c.flush()
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic replaces name with data via a temp file and rename, so
+// readers never see a half-written file.
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (c *checkpoint) autoFlush(interval time.Duration, log api.Logger) (stop func()) {
+	done := make(chan struct{})
+	if c == nil || interval <= 0 {
+		return func() {}
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.flush(); err != nil {
+					log.Errorf("writing checkpoint: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
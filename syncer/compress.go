@@ -0,0 +1,165 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// compressibleExts are the text formats Compress applies to. Images, fonts
+// and archives are already compressed and are always uploaded as they are.
+var compressibleExts = map[string]bool{
+	".html":        true,
+	".htm":         true,
+	".css":         true,
+	".js":          true,
+	".mjs":         true,
+	".json":        true,
+	".map":         true,
+	".svg":         true,
+	".xml":         true,
+	".txt":         true,
+	".webmanifest": true,
+}
+
+func (s *BCDNSyncer) compressible(name string) bool {
+	return s.Compress && compressibleExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// compressFile returns the gzip-compressed content of a file, or nil when
+// compressing does not make it smaller. The gzip header carries no name or
+// mtime, so the same content always compresses to the same bytes and the
+// checksum stays comparable across runs.
+func (s *BCDNSyncer) compressFile(name string) ([]byte, error) {
+	data, err := s.readLocal(name)
+	if err != nil {
+		return nil, err
+	}
+	return gzipBytes(data)
+}
+
+// gzipBytes compresses data as uploads are compressed, returning nil when
+// that does not make it smaller.
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(data) {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// storedChecksum is the checksum of the bytes an upload of the file would
+// store: the compressed body when Compress shrinks it, the file otherwise.
+func (s *BCDNSyncer) storedChecksum(name string) (string, error) {
+	if !s.compressible(name) {
+		return s.localChecksum(name)
+	}
+	data, err := s.compressFile(name)
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		return s.localChecksum(name)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// storedSize is the size the zone will list for the file once uploaded.
+func (s *BCDNSyncer) storedSize(name string, size int64) (int64, error) {
+	if !s.compressible(name) {
+		return size, nil
+	}
+	data, err := s.compressFile(name)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return size, nil
+	}
+	return int64(len(data)), nil
+}
+
+func (s *BCDNSyncer) localChecksum(name string) (string, error) {
+	f, err := s.openLocal(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readerChecksum(f)
+}
+
+// storedCompressed reports whether obj holds the local file at name as a
+// push with Compress stores it, gzip-compressed. Pulls compare this way
+// whatever Compress is set to, so a zone pushed compressed does not look
+// changed against the decompressed files a pull writes.
+func storedCompressed(name string, obj api.BCDNObject, sizeOnly bool) bool {
+	if !compressibleExts[strings.ToLower(filepath.Ext(name))] {
+		return false
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	gz, err := gzipBytes(data)
+	if err != nil || gz == nil {
+		return false
+	}
+	if sizeOnly {
+		return int64(len(gz)) == int64(obj.Length)
+	}
+	return strings.EqualFold(fmt.Sprintf("%x", sha256.Sum256(gz)), obj.Checksum)
+}
+
+// gunzipDownload decompresses the file at name, downloaded for dst, in
+// place when dst is a text format Compress applies to and the download
+// holds gzip data.
+func gunzipDownload(name, dst string) error {
+	if !compressibleExts[strings.ToLower(filepath.Ext(dst))] {
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if magic, err := br.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, zr); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return fmt.Errorf("decompressing: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Rename(out.Name(), name)
+}
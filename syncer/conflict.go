@@ -0,0 +1,53 @@
+package syncer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// Conflict is a file NoOverwriteNewer did not update because its zone copy
+// changed after the local file was last modified.
+type Conflict struct {
+	Path          string    `json:"path"`
+	RemoteChanged time.Time `json:"remoteChanged"`
+	LocalModified time.Time `json:"localModified"`
+}
+
+// remoteNewer reports whether, with NoOverwriteNewer, the zone copy of c
+// changed after the local file did. An object the zone manifest still
+// records as uploaded by a previous run is not an edit made in the zone,
+// whatever its time.
+func (s *BCDNSyncer) remoteNewer(c candidate, obj api.BCDNObject) bool {
+	if !s.NoOverwriteNewer || !obj.LastChanged.After(c.info.ModTime()) {
+		return false
+	}
+	return !s.manifest.recorded(c.relPath)
+}
+
+// recorded reports whether the object at p is the one a previous run
+// uploaded, as far as the manifest it started from shows.
+func (m *manifestState) recorded(p string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.entries[p].ModTime.IsZero()
+}
+
+func (m *syncMetrics) conflict(path string, remoteChanged, localModified time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	m.conflicts = append(m.conflicts, Conflict{Path: path, RemoteChanged: remoteChanged.UTC(), LocalModified: localModified.UTC()})
+}
+
+// conflictList returns the conflicts sorted by path.
+func (m *syncMetrics) conflictList() []Conflict {
+	m.Lock()
+	defer m.Unlock()
+	list := append([]Conflict(nil), m.conflicts...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
@@ -0,0 +1,193 @@
+package syncer
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A delete checkpoint is a journal: the first line is a JSON header holding
+// the local tree fingerprint and the full delete set, every following line
+// is a JSON-quoted path that has been deleted since.
+type deleteCheckpointHeader struct {
+	Fingerprint string   `json:"fingerprint"`
+	SyncPath    string   `json:"syncPath"`
+	Pending     []string `json:"pending"`
+}
+
+type deleteCheckpoint struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func createDeleteCheckpoint(path string, header deleteCheckpointHeader) (*deleteCheckpoint, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(header)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &deleteCheckpoint{path: path, f: f}, nil
+}
+
+func resumeDeleteCheckpoint(path string) (*deleteCheckpoint, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &deleteCheckpoint{path: path, f: f}, nil
+}
+
+func loadDeleteCheckpoint(path string) (*deleteCheckpointHeader, map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024*1024)
+	if !scanner.Scan() {
+		return nil, nil, fmt.Errorf("delete checkpoint %s is empty", path)
+	}
+	var header deleteCheckpointHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, nil, fmt.Errorf("invalid delete checkpoint header: %w", err)
+	}
+
+	deleted := map[string]bool{}
+	for scanner.Scan() {
+		var p string
+		// A torn last line from a crash is simply ignored.
+		if err := json.Unmarshal(scanner.Bytes(), &p); err == nil {
+			deleted[p] = true
+		}
+	}
+	return &header, deleted, scanner.Err()
+}
+
+func (c *deleteCheckpoint) markDeleted(p string) {
+	if c == nil {
+		return
+	}
+	line, _ := json.Marshal(p)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.f.Write(append(line, '\n'))
+}
+
+func (c *deleteCheckpoint) finish(completed bool) {
+	if c == nil {
+		return
+	}
+	c.f.Close()
+	if completed {
+		os.Remove(c.path)
+	}
+}
+
+// localFingerprint identifies the local trees by path, size and mtime
+// without reading file contents, so a resumed delete phase can tell whether
+// the delete set computed last time is still valid.
+func localFingerprint(sources []string, syncPath string) (string, error) {
+	var entries []string
+	for _, sourcePath := range sources {
+		err := filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			relPath, _ := filepath.Rel(sourcePath, path)
+			entries = append(entries, fmt.Sprintf("%s\x00%d\x00%d", filepath.ToSlash(relPath), info.Size(), info.ModTime().UnixNano()))
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	sort.Strings(entries)
+	sum := sha256.Sum256([]byte(syncPath + "\n" + strings.Join(entries, "\n")))
+	return fmt.Sprintf("%x", sum), nil
+}
+
+// resumeDeletes finishes an interrupted delete phase before the zone is
+// listed, so the listing no longer holds the paths it removed. It returns
+// the metrics of the resumed deletes, or nil when there is no usable
+// checkpoint; the caller goes on with a normal run either way.
+func (s *BCDNSyncer) resumeDeletes(ctx context.Context, sources []string, syncPath string) (*syncMetrics, error) {
+	header, deleted, err := loadDeleteCheckpoint(s.DeleteCheckpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read delete checkpoint: %w", err)
+	}
+
+	fingerprint, err := localFingerprint(sources, syncPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint local tree: %w", err)
+	}
+	if header.Fingerprint != fingerprint || header.SyncPath != syncPath {
+		s.logger().Infof("Local tree changed since the delete checkpoint was written, discarding it")
+		os.Remove(s.DeleteCheckpoint)
+		return nil, nil
+	}
+
+	remaining := []string{}
+	for _, p := range header.Pending {
+		if !deleted[p] {
+			remaining = append(remaining, p)
+		}
+	}
+	s.logger().Infof("Resuming delete phase from checkpoint: %d of %d deletes remaining", len(remaining), len(header.Pending))
+
+	cp, err := resumeDeleteCheckpoint(s.DeleteCheckpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open delete checkpoint: %w", err)
+	}
+	metrics := s.newMetrics()
+	metrics.deletedFile.Store(int64(len(remaining)))
+	err = s.processDeletesConcurrently(ctx, remaining, metrics, cp)
+	cp.finish(metrics.errors.Load() == 0 && ctx.Err() == nil)
+
+	if err != nil {
+		s.printSummary(metrics)
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		s.printSummary(metrics)
+		return nil, fmt.Errorf("sync interrupted: %w", ctx.Err())
+	}
+	return metrics, nil
+}
+
+// addDeletes carries the deletes recorded in prev, by a resumed delete
+// phase, into m.
+func (m *syncMetrics) addDeletes(prev *syncMetrics) {
+	prev.Lock()
+	defer prev.Unlock()
+	m.Lock()
+	defer m.Unlock()
+	m.deletedFile.Add(prev.deletedFile.Load())
+	m.bytesDeleted.Add(prev.bytesDeleted.Load())
+	m.errors.Add(prev.errors.Load())
+	m.deletedPaths = append(m.deletedPaths, prev.deletedPaths...)
+	m.fileErrors = append(m.fileErrors, prev.fileErrors...)
+}
@@ -0,0 +1,178 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// countFiles counts, for every directory below syncPath, the files below it
+// at any depth.
+func countFiles(syncPath string, paths []string) map[string]int {
+	counts := map[string]int{}
+	for _, p := range paths {
+		for dir := remoteDir(p); dir != "" && dir != syncPath; dir = remoteDir(dir) {
+			counts[dir]++
+		}
+	}
+	return counts
+}
+
+// collapseDeletes groups deleteOps by the topmost directory whose listed
+// files are all being deleted and that no local file maps into, so each
+// group can go in one directory delete. The remaining paths are returned
+// as single-file deletes.
+func (s *BCDNSyncer) collapseDeletes(syncPath string, deleteOps []string) (map[string][]string, []string) {
+	if s.remoteDirs == nil {
+		return nil, deleteOps
+	}
+	deleted := countFiles(syncPath, deleteOps)
+	groups := map[string][]string{}
+	var files []string
+	for _, p := range deleteOps {
+		top := ""
+		for dir := remoteDir(p); dir != "" && dir != syncPath; dir = remoteDir(dir) {
+			if deleted[dir] == s.remoteDirs[dir] && !s.localDirs[dir] {
+				top = dir
+			}
+		}
+		if top == "" {
+			files = append(files, p)
+			continue
+		}
+		groups[top] = append(groups[top], p)
+	}
+	for dir, paths := range groups {
+		if len(paths) < 2 {
+			files = append(files, paths...)
+			delete(groups, dir)
+		}
+	}
+	return groups, files
+}
+
+// processDirectoryDeletes removes each group with one directory delete and
+// returns the files of the groups that failed, to be deleted one by one.
+func (s *BCDNSyncer) processDirectoryDeletes(ctx context.Context, groups map[string][]string, metrics *syncMetrics, cp *deleteCheckpoint) []string {
+	dirs := make([]string, 0, len(groups))
+	for dir := range groups {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var fallback []string
+	var fallbackLock sync.Mutex
+	sem := make(chan struct{}, s.workers(s.DeleteConcurrency))
+	var wg sync.WaitGroup
+	for _, dir := range dirs {
+		wg.Add(1)
+		go func(dir string, paths []string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			if s.DryRun {
+				s.logger().Infof("DRY-RUN: Would delete directory %s (%d files)", dir, len(paths))
+			} else {
+				s.logger().Infof("Deleting directory %s (%d files)", dir, len(paths))
+				if s.breaker.acquire(ctx) != nil {
+					return
+				}
+				err := s.API.DeleteDirectoryContext(ctx, dir)
+				if errors.Is(err, api.ErrNotFound) {
+					err = nil
+				}
+				s.breaker.finish(ctx, err)
+				if err != nil {
+					s.logger().Infof("Deleting directory %s failed, deleting its files one by one: %v", dir, err)
+					fallbackLock.Lock()
+					fallback = append(fallback, paths...)
+					fallbackLock.Unlock()
+					return
+				}
+			}
+			fallbackLock.Lock()
+			s.removedDirs[dir] = true
+			fallbackLock.Unlock()
+			for _, p := range paths {
+				s.fileStart(metrics, p, 0)
+				if !s.DryRun {
+					cp.markDeleted(p)
+					s.manifest.remove(p)
+					metrics.Lock()
+					metrics.changed = append(metrics.changed, p)
+					metrics.bytesDeleted.Add(metrics.deleteSizes[p])
+					metrics.Unlock()
+				}
+				metrics.done("delete", p)
+				s.fileComplete(metrics, p, nil)
+			}
+		}(dir, groups[dir])
+	}
+	wg.Wait()
+	sort.Strings(fallback)
+	return fallback
+}
+
+// pruneEmptyDirs removes the remote directories this run emptied: every
+// listed file below them was deleted and no local file maps into them. With
+// Delete, directories that were already empty in the listing go too.
+func (s *BCDNSyncer) pruneEmptyDirs(ctx context.Context, syncPath string, metrics *syncMetrics) {
+	if s.remoteDirs == nil {
+		return
+	}
+	metrics.Lock()
+	deleted := countFiles(syncPath, metrics.deletedPaths)
+	metrics.Unlock()
+
+	empty := map[string]bool{}
+	for dir, n := range s.remoteDirs {
+		if deleted[dir] == n && !s.localDirs[dir] {
+			empty[dir] = true
+		}
+	}
+	if s.Delete {
+		for _, dir := range s.listedDirs {
+			if s.remoteDirs[dir] == 0 && !s.localDirs[dir] {
+				empty[dir] = true
+			}
+		}
+	}
+
+	var dirs []string
+	for dir := range empty {
+		top := true
+		for parent := remoteDir(dir); parent != "" && parent != syncPath; parent = remoteDir(parent) {
+			if empty[parent] {
+				top = false
+				break
+			}
+		}
+		if top && !s.removedDirs[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		if ctx.Err() != nil {
+			return
+		}
+		if s.DryRun {
+			s.logger().Infof("DRY-RUN: Would remove empty directory %s", dir)
+			continue
+		}
+		s.logger().Infof("Removing empty directory %s", dir)
+		err := s.API.DeleteDirectoryContext(ctx, dir)
+		if err != nil && !errors.Is(err, api.ErrNotFound) {
+			s.logger().Errorf("removing empty directory %s: %v", dir, err)
+			metrics.fail("delete", dir+"/", err)
+		}
+	}
+}
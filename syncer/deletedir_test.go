@@ -0,0 +1,94 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// The manifest does not list old/extra.txt, which another tool uploaded, so
+// a run reading the manifest must not delete old/ as a whole.
+func TestCollapseDeletesNeedsListing(t *testing.T) {
+	manifest, err := json.Marshal(zoneManifest{Generated: time.Now(), Objects: []manifestEntry{
+		{Path: "old/a.txt", Length: 1, Checksum: "AA"},
+		{Path: "old/b.txt", Length: 1, Checksum: "BB"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		useManifest bool
+		zone        []string
+		want        []string
+	}{
+		{"from manifest", true, []string{"a.txt", "b.txt", "extra.txt"}, []string{"old/a.txt", "old/b.txt"}},
+		{"from listing", false, []string{"a.txt", "b.txt"}, []string{"old/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var deleted []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				name := strings.TrimLeft(strings.TrimPrefix(r.URL.Path, "/zone"), "/")
+				switch r.Method {
+				case http.MethodGet:
+					switch name {
+					case "":
+						fmt.Fprintf(w, `[
+							{"Path": "/zone/", "ObjectName": %q, "Length": %d, "Checksum": "%X"},
+							{"Path": "/zone/", "ObjectName": "old", "IsDirectory": true}
+						]`, ManifestName, len(manifest), sha256.Sum256(manifest))
+					case ManifestName:
+						w.Write(manifest)
+					case "old/":
+						var entries []string
+						for _, f := range tt.zone {
+							entries = append(entries, fmt.Sprintf(`{"Path": "/zone/old/", "ObjectName": %q, "Length": 1}`, f))
+						}
+						fmt.Fprintf(w, "[%s]", strings.Join(entries, ","))
+					default:
+						fmt.Fprint(w, `[]`)
+					}
+				case http.MethodDelete:
+					mu.Lock()
+					deleted = append(deleted, name)
+					mu.Unlock()
+				case http.MethodPut:
+					w.WriteHeader(http.StatusCreated)
+				}
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			s := BCDNSyncer{
+				API:              api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+				Delete:           true,
+				MaxDeletePercent: 100,
+				UseManifest:      tt.useManifest,
+				Logger:           discardLogger{},
+			}
+			if _, err := s.Run(t.Context(), []string{dir}, ""); err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(deleted)
+			if strings.Join(deleted, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("deleted %v, want %v", deleted, tt.want)
+			}
+		})
+	}
+}
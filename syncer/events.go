@@ -0,0 +1,77 @@
+package syncer
+
+import "time"
+
+// Summary is the outcome of a sync run as passed to Progress.OnSummary.
+type Summary struct {
+	Total        int   `json:"total"`
+	New          int   `json:"newFile"`
+	Updated      int   `json:"modifiedFile"`
+	Deleted      int   `json:"deletedFile"`
+	Skipped      int   `json:"skipped"`
+	Errors       int   `json:"errors"`
+	VerifyFailed int   `json:"verifyFailed,omitempty"`
+	Deferred     int   `json:"deferred,omitempty"`
+	Transferred  int64 `json:"transferred,omitempty"`
+	// DeletesHeld is set when DeleteAfterVerify skipped the delete phase.
+	DeletesHeld bool `json:"deletesHeld,omitempty"`
+	// Conflicts counts the files NoOverwriteNewer kept from being updated.
+	Conflicts int `json:"conflicts,omitempty"`
+
+	BytesUploaded   int64         `json:"bytesUploaded"`
+	BytesDownloaded int64         `json:"bytesDownloaded,omitempty"`
+	BytesDeleted    int64         `json:"bytesDeleted"`
+	Elapsed         time.Duration `json:"elapsedNs"`
+}
+
+// Progress receives events while a sync runs, for programs embedding the
+// syncer that want to render their own progress. Uploads, downloads and
+// deletes all report a start and a completion; deletes have a size of 0.
+// Calls are serialized, so implementations need no locking of their own,
+// but they run on the worker goroutines and should return quickly.
+type Progress interface {
+	OnFileStart(relPath string, size int64)
+	OnFileComplete(relPath string, err error)
+	OnSummary(summary Summary)
+}
+
+func (m *syncMetrics) summary() Summary {
+	m.Lock()
+	defer m.Unlock()
+	return Summary{
+		Total:        int(m.total.Load()),
+		New:          int(m.newFile.Load()),
+		Updated:      int(m.modifiedFile.Load()),
+		Deleted:      int(m.deletedFile.Load()),
+		Skipped:      int(m.skipped.Load()),
+		Errors:       int(m.errors.Load()),
+		VerifyFailed: int(m.verifyFailed.Load()),
+		Deferred:     int(m.deferred.Load()),
+		Transferred:  m.transferred.Load(),
+		DeletesHeld:  m.deletesHeld,
+		Conflicts:    len(m.conflicts),
+
+		BytesUploaded:   m.bytesUploaded.Load(),
+		BytesDownloaded: m.bytesDownloaded.Load(),
+		BytesDeleted:    m.bytesDeleted.Load(),
+		Elapsed:         time.Since(m.started),
+	}
+}
+
+func (s *BCDNSyncer) fileStart(m *syncMetrics, relPath string, size int64) {
+	if s.Progress == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	s.Progress.OnFileStart(relPath, size)
+}
+
+func (s *BCDNSyncer) fileComplete(m *syncMetrics, relPath string, err error) {
+	if s.Progress == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	s.Progress.OnFileComplete(relPath, err)
+}
@@ -0,0 +1,125 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// fatalStop ends a phase on the first error that retrying other files cannot
+// fix, such as a rejected access key, instead of failing every file in turn.
+type fatalStop struct {
+	once   sync.Once
+	err    error
+	cancel context.CancelFunc
+}
+
+func newFatalStop(ctx context.Context) (context.Context, *fatalStop) {
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, &fatalStop{cancel: cancel}
+}
+
+func (f *fatalStop) check(err error) {
+	if !errors.Is(err, api.ErrUnauthorized) {
+		return
+	}
+	f.once.Do(func() {
+		f.err = fmt.Errorf("storage rejected the access key, check BCDN_APIKEY and the zone name: %w", err)
+		f.cancel()
+	})
+}
+
+// ErrInterrupted is returned by a run stopped through Interrupt.
+var ErrInterrupted = errors.New("sync interrupted")
+
+// failFastError is the cancel cause of a run stopped by FailFast or
+// MaxErrors; last is the failure that reached the limit.
+type failFastError struct {
+	last  FileError
+	count int64
+}
+
+func (e *failFastError) Error() string {
+	if e.count == 1 {
+		return fmt.Sprintf("stopped after the first failure: %s %s: %v", e.last.Op, e.last.Path, e.last.Err)
+	}
+	return fmt.Sprintf("stopped after %d failed files, the last %s %s: %v", e.count, e.last.Op, e.last.Path, e.last.Err)
+}
+
+func (e *failFastError) Unwrap() error {
+	return e.last.Err
+}
+
+// errorLimit is the number of failed files that stops the run, or zero.
+func (s *BCDNSyncer) errorLimit() int {
+	if s.FailFast {
+		return 1
+	}
+	return s.MaxErrors
+}
+
+// withLimits runs fn under Deadline and, with FailFast or MaxErrors, cancels
+// it once errorLimit files have failed. With Interrupt it also cancels fn once Interrupt
+// is closed, leaving transfers in flight to finish; see inFlight.
+func (s *BCDNSyncer) withLimits(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Deadline)
+		defer cancel()
+	}
+	s.abort = nil
+	if s.errorLimit() > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		s.abort = cancel
+	}
+	s.uninterrupted = nil
+	if s.Interrupt != nil {
+		s.uninterrupted = ctx
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		go func() {
+			select {
+			case <-s.Interrupt:
+				cancel(ErrInterrupted)
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	err := fn(ctx)
+	var stopped *failFastError
+	switch {
+	case errors.As(context.Cause(ctx), &stopped):
+		return stopped
+	case err != nil && errors.Is(context.Cause(ctx), ErrInterrupted):
+		return ErrInterrupted
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("deadline of %s exceeded: %w", s.Deadline, err)
+	}
+	return err
+}
+
+// inFlight returns the context for an upload, download or delete that has
+// started. It ignores an Interrupt, so the transfer is not cut off halfway,
+// but ends when ctx is cancelled for any other reason.
+func (s *BCDNSyncer) inFlight(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.uninterrupted == nil {
+		return ctx, func() {}
+	}
+	opCtx, cancel := context.WithCancel(s.uninterrupted)
+	stop := context.AfterFunc(ctx, func() {
+		if !errors.Is(context.Cause(ctx), ErrInterrupted) {
+			cancel()
+		}
+	})
+	return opCtx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,143 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+func TestStatusHandling(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		status      int
+		wantErr     error
+		wantFailed  int
+		wantDeleted int
+		maxRequests int32
+	}{
+		{"rejected key stops uploads", http.MethodPut, http.StatusUnauthorized, api.ErrUnauthorized, -1, 0, 2},
+		{"deleting a missing file succeeds", http.MethodDelete, http.StatusNotFound, nil, 0, 10, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == tt.method {
+					requests.Add(1)
+					w.WriteHeader(tt.status)
+					return
+				}
+				switch r.Method {
+				case http.MethodGet:
+					var entries string
+					for i := 0; i < 10; i++ {
+						if i > 0 {
+							entries += ","
+						}
+						entries += fmt.Sprintf(`{"Path": "/zone/", "ObjectName": "old%d.txt", "Length": 1}`, i)
+					}
+					fmt.Fprintf(w, "[%s]", entries)
+				case http.MethodPut:
+					w.WriteHeader(http.StatusCreated)
+				}
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			for i := 0; i < 10; i++ {
+				if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("new%d.txt", i)), []byte("new"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := BCDNSyncer{
+				API:              api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL, MaxRetries: -1},
+				Delete:           true,
+				MaxDeletePercent: 100,
+				Concurrency:      1,
+				Logger:           discardLogger{},
+			}
+			res, err := s.Run(t.Context(), []string{dir}, "")
+			if tt.wantErr == nil && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if n := requests.Load(); n > tt.maxRequests {
+				t.Errorf("sent %d %s requests, want at most %d", n, tt.method, tt.maxRequests)
+			}
+			if tt.wantFailed >= 0 && len(res.Errors) != tt.wantFailed {
+				t.Errorf("got errors %v, want %d", res.Errors, tt.wantFailed)
+			}
+			if len(res.Deleted) != tt.wantDeleted {
+				t.Errorf("deleted %v, want %d", res.Deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestErrorLimitStopsRun(t *testing.T) {
+	const files, concurrency = 100, 4
+	tests := []struct {
+		name      string
+		failFast  bool
+		maxErrors int
+		message   string
+	}{
+		{"fail fast", true, 0, "stopped after the first failure"},
+		{"max errors", false, 3, "stopped after 3 failed files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var puts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					fmt.Fprint(w, `[]`)
+					return
+				}
+				puts.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			for i := 0; i < files; i++ {
+				if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%03d.txt", i)), []byte("x"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := BCDNSyncer{
+				API:         api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL, MaxRetries: -1},
+				Concurrency: concurrency,
+				FailFast:    tt.failFast,
+				MaxErrors:   tt.maxErrors,
+				Logger:      discardLogger{},
+			}
+			start := time.Now()
+			_, err := s.Run(t.Context(), []string{dir}, "")
+			elapsed := time.Since(start)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("got %v, want %q", err, tt.message)
+			}
+			limit := max(tt.maxErrors, 1)
+			// Uploads already started may finish; no new ones begin.
+			if n := int(puts.Load()); n > limit+concurrency {
+				t.Errorf("sent %d uploads, want at most %d", n, limit+concurrency)
+			}
+			if elapsed > time.Second {
+				t.Errorf("run took %s to stop", elapsed)
+			}
+		})
+	}
+}
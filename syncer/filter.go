@@ -0,0 +1,99 @@
+package syncer
+
+import (
+	"path"
+	"strings"
+)
+
+// matchPattern reports whether relPath matches a selection pattern. A
+// pattern without a slash matches the last path component at any depth, a
+// pattern containing one is matched against the whole relative path, and
+// "**" stands for any number of directories. A trailing slash restricts the
+// pattern to directories.
+func matchPattern(pattern, relPath string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(relPath))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(relPath, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+func matchAny(patterns []string, relPath string, isDir bool) bool {
+	for _, p := range patterns {
+		if matchPattern(p, relPath, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludedDir reports whether a directory, and so everything below it, is
+// filtered out by Exclude or .bunnyignore.
+func (s *BCDNSyncer) excludedDir(relPath string) bool {
+	return matchAny(s.Exclude, relPath, true) || s.ignore.ignored(relPath, true)
+}
+
+// allowedExt reports whether relPath ends in one of Extensions, compared
+// without case; an empty list allows every file.
+func (s *BCDNSyncer) allowedExt(relPath string) bool {
+	if len(s.Extensions) == 0 {
+		return true
+	}
+	name := strings.ToLower(path.Base(relPath))
+	for _, ext := range s.Extensions {
+		ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+		if ext != "" && strings.HasSuffix(name, "."+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// selected applies .bunnyignore, Extensions, Include and Exclude to a file
+// path relative to the source root. Exclude wins over Include; an empty
+// Include selects everything. Ignore files themselves are never selected.
+func (s *BCDNSyncer) selected(relPath string) bool {
+	if path.Base(relPath) == ignoreFileName || s.ignore.ignoredPath(relPath) || !s.allowedExt(relPath) {
+		return false
+	}
+	if len(s.Include) == 0 && len(s.Exclude) == 0 {
+		return true
+	}
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if s.excludedDir(strings.Join(parts[:i], "/")) {
+			return false
+		}
+	}
+	if matchAny(s.Exclude, relPath, false) {
+		return false
+	}
+	return len(s.Include) == 0 || matchAny(s.Include, relPath, false)
+}
@@ -0,0 +1,127 @@
+package syncer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		isDir         bool
+		want          bool
+	}{
+		{"*.map", "app.js.map", false, true},
+		{"*.map", "js/vendor/app.js.map", false, true},
+		{"drafts/", "drafts", true, true},
+		{"drafts/", "drafts", false, false},
+		{"docs/*.html", "docs/index.html", false, true},
+		{"docs/*.html", "docs/api/index.html", false, false},
+		{"/docs/*.html", "docs/index.html", false, true},
+		{"docs/**/*.html", "docs/index.html", false, true},
+		{"docs/**/*.html", "docs/api/v1/index.html", false, true},
+		{"docs/**/*.html", "blog/docs/index.html", false, false},
+		{"**/tmp", "a/b/tmp", true, true},
+		{"**/tmp", "tmp", true, true},
+		{"assets/**", "assets/img/logo.png", false, true},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.path, tt.isDir); got != tt.want {
+			t.Errorf("matchPattern(%q, %q, %v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestSelected(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		path    string
+		want    bool
+	}{
+		{"no patterns", nil, nil, "any/file.txt", true},
+		{"include matches", []string{"*.html"}, nil, "blog/post.html", true},
+		{"include misses", []string{"*.html"}, nil, "blog/post.css", false},
+		{"nested include", []string{"docs/**/*.html"}, nil, "docs/a/b/page.html", true},
+		{"exclude wins over include", []string{"*.html"}, []string{"drafts/"}, "blog/drafts/post.html", false},
+		{"exclude file over include", []string{"docs/**"}, []string{"*.tmp"}, "docs/a/x.tmp", false},
+		{"excluded parent directory", nil, []string{"node_modules/"}, "web/node_modules/pkg/index.js", false},
+		{"excluded nested path", nil, []string{"docs/private/**"}, "docs/private/a/secret.html", false},
+		{"sibling of excluded path", nil, []string{"docs/private/**"}, "docs/public/page.html", true},
+	}
+	for _, tt := range tests {
+		s := BCDNSyncer{Include: tt.include, Exclude: tt.exclude}
+		if got := s.selected(tt.path); got != tt.want {
+			t.Errorf("%s: selected(%q) = %v, want %v", tt.name, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExtensionAllowlist(t *testing.T) {
+	tests := []struct {
+		extensions []string
+		path       string
+		want       bool
+	}{
+		{nil, "backup/site.bak", true},
+		{[]string{"html", "css"}, "backup/site.bak", false},
+		{[]string{"html", "css"}, "index.html", true},
+		{[]string{".HTML"}, "docs/Page.Html", true},
+		{[]string{"gz"}, "dist/app.js.gz", true},
+		{[]string{"js"}, "dist/app.js.bak", false},
+		{[]string{"bak"}, "notes.bak", true},
+		{[]string{""}, "notes.txt", false},
+	}
+	for _, tt := range tests {
+		s := BCDNSyncer{Extensions: tt.extensions}
+		if got := s.selected(tt.path); got != tt.want {
+			t.Errorf("Extensions %v: selected(%q) = %v, want %v", tt.extensions, tt.path, got, tt.want)
+		}
+	}
+}
+
+// Files outside the allowlist are neither uploaded nor deleted.
+func TestExtensionAllowlistSync(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `[{"Path": "/zone/", "ObjectName": "old.bak", "Length": 3}]`)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, "/zone/"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	for _, name := range []string{"index.html", "index.html.bak"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := BCDNSyncer{
+		API:              api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+		Extensions:       []string{"html"},
+		Delete:           true,
+		MaxDeletePercent: 100,
+		Logger:           discardLogger{},
+	}
+	if _, err := s.Run(t.Context(), []string{dir}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0] != "PUT index.html" {
+		t.Errorf("sent %v, want only PUT index.html", requests)
+	}
+}
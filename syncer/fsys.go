@@ -0,0 +1,100 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// SyncFS pushes the contents of fsys, such as an embed.FS or an in-memory
+// tree, to syncPath. It compares and uploads like Sync with a single source.
+// Files are opened through fsys only, so symlinks are not followed, and
+// files without a modification time (as in an embed.FS) are always compared
+// by checksum. Pull mode, git diffs and delete checkpoints need an on-disk
+// source and are rejected.
+func (s *BCDNSyncer) SyncFS(fsys fs.FS, syncPath string) error {
+	return s.SyncFSContext(context.Background(), fsys, syncPath)
+}
+
+func (s *BCDNSyncer) SyncFSContext(ctx context.Context, fsys fs.FS, syncPath string) error {
+	if s.Direction == DirectionPull || s.GitDiff != "" || s.DeleteCheckpoint != "" {
+		return fmt.Errorf("syncing a file system cannot be combined with pull mode, a git diff or a delete checkpoint")
+	}
+	s.sourceFS = fsys
+	defer func() { s.sourceFS = nil }()
+	return s.SyncSourcesContext(ctx, []string{"."}, syncPath)
+}
+
+// The helpers below read the local side of a push, from sourceFS during
+// SyncFS and from the OS file system otherwise.
+
+func (s *BCDNSyncer) openLocal(name string) (fs.File, error) {
+	if s.sourceFS != nil {
+		return s.sourceFS.Open(name)
+	}
+	return os.Open(name)
+}
+
+func (s *BCDNSyncer) readLocal(name string) ([]byte, error) {
+	if s.sourceFS != nil {
+		return fs.ReadFile(s.sourceFS, name)
+	}
+	return os.ReadFile(name)
+}
+
+func (s *BCDNSyncer) statLocal(name string) (fs.FileInfo, error) {
+	if s.sourceFS != nil {
+		return fs.Stat(s.sourceFS, name)
+	}
+	return os.Stat(name)
+}
+
+// localPath joins a slash-separated path below root.
+func (s *BCDNSyncer) localPath(root, relPath string) string {
+	if s.sourceFS != nil {
+		return path.Join(root, relPath)
+	}
+	return filepath.Join(root, filepath.FromSlash(relPath))
+}
+
+// walkFS is walkTree for sourceFS.
+func (s *BCDNSyncer) walkFS(syncPath string, metrics *syncMetrics, add func(candidate) error) error {
+	return fs.WalkDir(s.sourceFS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			s.logger().Errorf("accessing path %q: %v\n", p, err)
+			metrics.fail("walk", p, err)
+			return nil
+		}
+		if p == "." {
+			return nil
+		}
+		if s.skipName(d.Name()) {
+			s.logDebug("Skipping junk or hidden file %s", p)
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if s.excludedDir(p) || s.outsidePrefix(p) {
+				s.logDebug("Skipping excluded directory %s", p)
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			s.logger().Infof("Skipping %s, it is not a regular file", p)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			s.logger().Errorf("accessing path %q: %v\n", p, err)
+			metrics.fail("walk", p, err)
+			return nil
+		}
+		return s.addFile(p, p, info, syncPath, metrics, add)
+	})
+}
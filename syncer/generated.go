@@ -0,0 +1,174 @@
+package syncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+const indexFileName = "index.json"
+
+type indexEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+type directoryIndex struct {
+	Path        string       `json:"path"`
+	Directories []string     `json:"directories"`
+	Files       []indexEntry `json:"files"`
+}
+
+// syncGenerated uploads a synthesized object when its content differs from
+// the remote copy and removes it from objMap so it never becomes a delete
+// candidate.
+func (s *BCDNSyncer) syncGenerated(ctx context.Context, relPath string, content []byte, objMap map[string]api.BCDNObject, metrics *syncMetrics) {
+	checksum := fmt.Sprintf("%x", sha256.Sum256(content))
+	obj, exists := objMap[relPath]
+	delete(objMap, relPath)
+
+	if exists && strings.EqualFold(obj.Checksum, checksum) {
+		s.logDebug("Generated object %s is up to date", relPath)
+		return
+	}
+
+	if s.DryRun {
+		s.logger().Infof("DRY-RUN: Would upload generated %s", relPath)
+		return
+	}
+
+	if err := s.API.UploadContext(ctx, relPath, content, checksum); err != nil {
+		s.logger().Errorf("upload failed for generated %s: %v", relPath, err)
+		metrics.fail("upload", relPath, err)
+		return
+	}
+	s.manifest.put(relPath, int64(len(content)), checksum, time.Time{})
+	metrics.Lock()
+	metrics.changed = append(metrics.changed, relPath)
+	metrics.bytesUploaded.Add(int64(len(content)))
+	metrics.Unlock()
+	if exists {
+		metrics.done("update", relPath)
+	} else {
+		metrics.done("upload", relPath)
+	}
+	s.logger().Infof("Uploaded generated %s", relPath)
+}
+
+func (s *BCDNSyncer) syncDirectoryIndexes(ctx context.Context, syncPath string, files []localFile, objMap map[string]api.BCDNObject, metrics *syncMetrics) {
+	indexes := map[string]*directoryIndex{}
+	var ensure func(dir string) *directoryIndex
+	ensure = func(dir string) *directoryIndex {
+		if idx, ok := indexes[dir]; ok {
+			return idx
+		}
+		idx := &directoryIndex{Path: dir, Directories: []string{}, Files: []indexEntry{}}
+		indexes[dir] = idx
+		if dir != syncPath {
+			parent := ensure(remoteDir(dir))
+			parent.Directories = append(parent.Directories, path.Base(dir))
+		}
+		return idx
+	}
+
+	hasLocalIndex := map[string]bool{}
+	ensure(syncPath)
+	for _, f := range files {
+		dir := remoteDir(f.relPath)
+		name := path.Base(f.relPath)
+		if name == indexFileName {
+			hasLocalIndex[dir] = true
+			continue
+		}
+		idx := ensure(dir)
+		idx.Files = append(idx.Files, indexEntry{Name: name, Size: f.size})
+	}
+
+	for dir, idx := range indexes {
+		if hasLocalIndex[dir] {
+			s.logDebug("Skipping generated index for %q: local %s exists", dir, indexFileName)
+			continue
+		}
+		sort.Strings(idx.Directories)
+		sort.Slice(idx.Files, func(i, j int) bool { return idx.Files[i].Name < idx.Files[j].Name })
+
+		content, err := json.MarshalIndent(idx, "", "  ")
+		if err != nil {
+			s.logger().Errorf("building index for %q: %v", dir, err)
+			metrics.fail("generate", dir, err)
+			continue
+		}
+		s.syncGenerated(ctx, path.Join(dir, indexFileName), content, objMap, metrics)
+	}
+}
+
+func remoteDir(relPath string) string {
+	dir := path.Dir(relPath)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+const sitemapFileName = "sitemap.xml"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// syncSitemap writes the sitemap next to the synced files, at the top of
+// syncPath.
+func (s *BCDNSyncer) syncSitemap(ctx context.Context, syncPath string, files []localFile, objMap map[string]api.BCDNObject, metrics *syncMetrics) {
+	set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	base := strings.TrimSuffix(s.BaseURL, "/")
+	sitemapPath := path.Join(syncPath, sitemapFileName)
+	for _, f := range files {
+		if f.relPath == sitemapPath {
+			s.logDebug("Skipping generated sitemap: local %s exists", sitemapPath)
+			return
+		}
+		ext := strings.ToLower(path.Ext(f.relPath))
+		if ext != ".html" && ext != ".htm" {
+			continue
+		}
+
+		urlPath := f.relPath
+		if name := path.Base(urlPath); name == "index.html" || name == "index.htm" {
+			urlPath = strings.TrimSuffix(urlPath, name)
+		}
+		segments := strings.Split(urlPath, "/")
+		for i, seg := range segments {
+			segments[i] = url.PathEscape(seg)
+		}
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     base + "/" + strings.Join(segments, "/"),
+			LastMod: f.modTime.UTC().Format("2006-01-02"),
+		})
+	}
+	sort.Slice(set.URLs, func(i, j int) bool { return set.URLs[i].Loc < set.URLs[j].Loc })
+
+	content, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		s.logger().Errorf("building sitemap: %v", err)
+		metrics.fail("generate", sitemapPath, err)
+		return
+	}
+	content = append([]byte(xml.Header), content...)
+	s.syncGenerated(ctx, sitemapPath, content, objMap, metrics)
+}
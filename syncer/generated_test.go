@@ -0,0 +1,80 @@
+package syncer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+func TestSitemapUnderSyncPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		syncPath   string
+		wantPath   string
+		wantSource bool
+	}{
+		{"zone root", map[string]string{"index.html": "<p>home</p>"}, "", "sitemap.xml", false},
+		{"sync path", map[string]string{"index.html": "<p>home</p>"}, "site", "site/sitemap.xml", false},
+		{"local sitemap", map[string]string{"index.html": "<p>home</p>", "sitemap.xml": "<urlset/>"}, "site", "site/sitemap.xml", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			uploads := map[string]string{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					fmt.Fprint(w, `[]`)
+				case http.MethodPut:
+					body, _ := io.ReadAll(r.Body)
+					mu.Lock()
+					uploads[strings.TrimPrefix(r.URL.Path, "/zone/")] = string(body)
+					mu.Unlock()
+					w.WriteHeader(http.StatusCreated)
+				}
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := BCDNSyncer{
+				API:             api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+				GenerateSitemap: true,
+				BaseURL:         "https://example.com",
+				Logger:          discardLogger{},
+			}
+			if _, err := s.Run(t.Context(), []string{dir}, tt.syncPath); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := uploads[tt.wantPath]
+			if !ok {
+				t.Fatalf("no upload to %s, got %v", tt.wantPath, uploads)
+			}
+			if tt.wantSource {
+				if got != tt.files["sitemap.xml"] {
+					t.Errorf("%s holds %q, want the local sitemap", tt.wantPath, got)
+				}
+			} else if !strings.Contains(got, "<loc>https://example.com/") {
+				t.Errorf("%s holds %q, want a generated sitemap", tt.wantPath, got)
+			}
+			if tt.wantPath != "sitemap.xml" {
+				if _, ok := uploads["sitemap.xml"]; ok {
+					t.Error("sitemap was also uploaded to the zone root")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+type gitChange struct {
+	status  byte
+	path    string
+	oldPath string
+}
+
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitRange splits a <base>..<head> range; an empty head is HEAD and an
+// invalid range gives an empty base.
+func gitRange(diffRange string) (base, head string) {
+	base, head, ok := strings.Cut(diffRange, "..")
+	if !ok {
+		return "", ""
+	}
+	if head == "" {
+		head = "HEAD"
+	}
+	return base, head
+}
+
+// gitFileCount returns the number of files git tracks below dir at ref.
+func gitFileCount(dir, ref string) (int, error) {
+	out, err := runGit(dir, "ls-tree", "-r", "-z", "--name-only", ref, "--", ".")
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Count(out, []byte{0}), nil
+}
+
+func gitChanges(sourcePath, diffRange string) ([]gitChange, error) {
+	base, head := gitRange(diffRange)
+	if base == "" {
+		return nil, fmt.Errorf("invalid --git-diff range %q, expected <base>..<head>", diffRange)
+	}
+
+	if out, err := runGit(sourcePath, "rev-parse", "--is-inside-work-tree"); err != nil || strings.TrimSpace(string(out)) != "true" {
+		return nil, fmt.Errorf("source path %s is not inside a git work tree", sourcePath)
+	}
+
+	// -z keeps paths unquoted; --relative limits and relativizes output to
+	// the source directory.
+	out, err := runGit(sourcePath, "diff", "--name-status", "-z", "--relative", "-M", base, head)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	var changes []gitChange
+	for i := 0; i < len(fields) && fields[i] != ""; {
+		status := fields[i][0]
+		switch status {
+		case 'R', 'C':
+			if i+2 >= len(fields) {
+				return nil, fmt.Errorf("unexpected git diff output")
+			}
+			changes = append(changes, gitChange{status: status, oldPath: fields[i+1], path: fields[i+2]})
+			i += 3
+		default:
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("unexpected git diff output")
+			}
+			changes = append(changes, gitChange{status: status, path: fields[i+1]})
+			i += 2
+		}
+	}
+	return changes, nil
+}
+
+// syncGitDiff uploads only the files changed between two refs, taking their
+// contents from the working tree, and deletes removed or renamed-away files
+// when Delete is set. No remote listing or local walk is performed.
+func (s *BCDNSyncer) syncGitDiff(ctx context.Context, sourcePath, syncPath string) error {
+	changes, err := gitChanges(sourcePath, s.GitDiff)
+	if err != nil {
+		return err
+	}
+	s.logger().Infof("git diff %s: %d changed paths", s.GitDiff, len(changes))
+
+	metrics := s.newMetrics()
+	operations := []operation{}
+	deleteOps := []string{}
+	for _, c := range changes {
+		if c.oldPath != "" && c.status == 'R' && !s.isJunk(c.oldPath) && s.selected(c.oldPath) && s.underPrefix(c.oldPath) {
+			deleteOps = append(deleteOps, s.remotePath(syncPath, c.oldPath))
+		}
+		if s.isJunk(c.path) || !s.selected(c.path) || !s.underPrefix(c.path) {
+			continue
+		}
+		relPath := s.remotePath(syncPath, c.path)
+		if c.status == 'D' {
+			deleteOps = append(deleteOps, relPath)
+			continue
+		}
+
+		metrics.total.Add(1)
+		localPath := filepath.Join(sourcePath, filepath.FromSlash(c.path))
+		info, err := os.Stat(localPath)
+		if err != nil {
+			s.logger().Errorf("changed file %s missing from working tree: %v", c.path, err)
+			metrics.fail("read", c.path, err)
+			continue
+		}
+		if s.tooLarge(c.path, info.Size()) {
+			metrics.skip(c.path)
+			continue
+		}
+		if s.private(c.path) {
+			s.logger().Errorf("refusing to upload %s: it matches --private, but %v", relPath, api.ErrNoAccessControl)
+			metrics.fail("upload", relPath, api.ErrNoAccessControl)
+			continue
+		}
+		if !s.contentTypeAllowed(relPath) {
+			s.logger().Errorf("refusing to upload %s: content type is not allowed", relPath)
+			metrics.fail("upload", relPath, errors.New("content type is not allowed"))
+			continue
+		}
+		if c.status == 'M' || c.status == 'T' {
+			metrics.modifiedFile.Add(1)
+		} else {
+			metrics.newFile.Add(1)
+		}
+		operations = append(operations, operation{
+			action:  "upload",
+			path:    localPath,
+			relPath: relPath,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			isNew:   c.status != 'M' && c.status != 'T',
+			reason:  fmt.Sprintf("git status %c", c.status),
+		})
+	}
+
+	for _, o := range operations {
+		metrics.planOp(o.report())
+	}
+
+	if len(operations) > 0 {
+		if _, err := s.processOperationsConcurrently(ctx, operations, metrics, nil); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			s.printSummary(metrics)
+			return fmt.Errorf("sync interrupted: %w", ctx.Err())
+		}
+	}
+
+	if s.Delete && len(deleteOps) > 0 && !s.holdDeletes(metrics) {
+		// Without a listing, the guards measure against the files git tracks
+		// below the source: at base for the zone, at head for the source.
+		base, head := gitRange(s.GitDiff)
+		remoteCount, err := gitFileCount(sourcePath, base)
+		if err != nil {
+			s.printSummary(metrics)
+			return err
+		}
+		localCount, err := gitFileCount(sourcePath, head)
+		if err != nil {
+			s.printSummary(metrics)
+			return err
+		}
+		if deleteOps, err = s.approveDeletes(deleteOps, remoteCount, localCount, "remote"); err != nil {
+			s.printSummary(metrics)
+			return err
+		}
+		metrics.deletedFile.Store(int64(len(deleteOps)))
+		for _, p := range deleteOps {
+			metrics.plan("delete", p, 0, "deleted or renamed in git")
+		}
+		if err := s.processDeletesConcurrently(ctx, deleteOps, metrics, nil); err != nil {
+			s.printSummary(metrics)
+			return err
+		}
+	}
+
+	if s.Purge {
+		s.purgeChanged(ctx, metrics)
+	}
+
+	s.printSummary(metrics)
+	return nil
+}
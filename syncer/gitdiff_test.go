@@ -0,0 +1,93 @@
+package syncer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+func TestGitDiffDeleteGuards(t *testing.T) {
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "test")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "test@example.com")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		if _, err := runGit(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	for i := 0; i < 10; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d.txt", i)), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "base")
+	git("tag", "base")
+	for i := 0; i < 6; i++ {
+		git("rm", "-q", fmt.Sprintf("f%d.txt", i))
+	}
+	git("commit", "-q", "-m", "head")
+
+	tests := []struct {
+		name        string
+		maxPercent  float64
+		confirm     bool
+		wantErr     bool
+		wantDeletes int32
+		wantAsked   bool
+	}{
+		{"over the ceiling", 50, true, true, 0, false},
+		{"declined", 100, false, false, 0, true},
+		{"confirmed", 100, true, false, 6, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deletes atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					fmt.Fprint(w, `[]`)
+				case http.MethodDelete:
+					deletes.Add(1)
+				}
+			}))
+			defer srv.Close()
+
+			asked := false
+			s := BCDNSyncer{
+				API:              api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+				GitDiff:          "base..HEAD",
+				Delete:           true,
+				MaxDeletePercent: tt.maxPercent,
+				ConfirmDeletes: func(paths []string) bool {
+					asked = true
+					return tt.confirm
+				},
+				Logger: discardLogger{},
+			}
+			_, err := s.Run(t.Context(), []string{dir}, "")
+			if tt.wantErr != (err != nil) || err != nil && !strings.Contains(err.Error(), "max-delete-percent") {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if asked != tt.wantAsked {
+				t.Errorf("asked for confirmation: %v, want %v", asked, tt.wantAsked)
+			}
+			if n := deletes.Load(); n != tt.wantDeletes {
+				t.Errorf("sent %d deletes, want %d", n, tt.wantDeletes)
+			}
+		})
+	}
+}
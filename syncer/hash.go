@@ -0,0 +1,57 @@
+package syncer
+
+import (
+	"context"
+	"sync"
+)
+
+// Checksum algorithms for ChecksumAlgo.
+const (
+	// ChecksumSHA256 hashes the bytes as stored, after any compression, which
+	// is the digest the zone lists in uppercase hex.
+	ChecksumSHA256 = "sha256"
+	// ChecksumNone never hashes files.
+	ChecksumNone = "none"
+)
+
+type hashResult struct {
+	checksum string
+	err      error
+}
+
+// hashCandidates computes the checksums the comparison needs across
+// Concurrency workers, so reading and hashing overlap instead of running one
+// file at a time. Results are indexed like candidates; entries for which
+// need returns false are left empty.
+func (s *BCDNSyncer) hashCandidates(ctx context.Context, candidates []candidate, need func(candidate) bool) []hashResult {
+	results := make([]hashResult, len(candidates))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < s.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					results[i].err = ctx.Err()
+					continue
+				}
+				c := candidates[i]
+				results[i].checksum, results[i].err = s.fileChecksum(c.path, c.info.Size(), c.info.ModTime())
+			}
+		}()
+	}
+	for i, c := range candidates {
+		if need(c) {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// sizeOnly reports whether files are compared by size alone.
+func (s *BCDNSyncer) sizeOnly() bool {
+	return s.SizeOnly || s.ChecksumAlgo == ChecksumNone
+}
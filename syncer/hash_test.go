@@ -0,0 +1,138 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// A file uploaded once compares as up to date on the next run.
+func TestUploadedFileIsUpToDate(t *testing.T) {
+	tests := []struct {
+		algo     string
+		compress bool
+	}{
+		{ChecksumSHA256, false},
+		{ChecksumSHA256, true},
+		{ChecksumNone, false},
+		{ChecksumNone, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s compress=%v", tt.algo, tt.compress), func(t *testing.T) {
+			var mu sync.Mutex
+			stored := map[string][]byte{}
+			var checksumHeaders []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				name := strings.TrimPrefix(r.URL.Path, "/zone/")
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.Method {
+				case http.MethodPut:
+					body, _ := io.ReadAll(r.Body)
+					// Like Bunny, reject a body that does not match the
+					// Checksum header.
+					checksum := r.Header.Get("Checksum")
+					checksumHeaders = append(checksumHeaders, checksum)
+					if checksum != "" && checksum != fmt.Sprintf("%X", sha256.Sum256(body)) {
+						http.Error(w, "checksum mismatch", http.StatusBadRequest)
+						return
+					}
+					stored[name] = body
+					w.WriteHeader(http.StatusCreated)
+				case http.MethodGet:
+					var entries []string
+					for n, body := range stored {
+						entries = append(entries, fmt.Sprintf(`{"Path": "/zone/", "ObjectName": %q, "Length": %d, "Checksum": "%X"}`, n, len(body), sha256.Sum256(body)))
+					}
+					fmt.Fprintf(w, "[%s]", strings.Join(entries, ","))
+				}
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			files := map[string]string{
+				"index.html": strings.Repeat("<p>compressible</p>\n", 50),
+				"logo.png":   "\x89PNG not compressed",
+			}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			newSyncer := func() BCDNSyncer {
+				return BCDNSyncer{
+					API:          api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+					ChecksumAlgo: tt.algo,
+					Compress:     tt.compress,
+					Logger:       discardLogger{},
+				}
+			}
+			s := newSyncer()
+			res, err := s.Run(t.Context(), []string{dir}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Uploaded) != len(files) || len(res.Errors) != 0 {
+				t.Fatalf("first run uploaded %v with errors %v", res.Uploaded, res.Errors)
+			}
+			for _, h := range checksumHeaders {
+				if (h == "") != (tt.algo == ChecksumNone) {
+					t.Errorf("upload sent Checksum %q with algo %s", h, tt.algo)
+				}
+			}
+
+			s = newSyncer()
+			res, err = s.Run(t.Context(), []string{dir}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Uploaded)+len(res.Updated) != 0 || len(res.Skipped) != len(files) {
+				t.Errorf("second run uploaded %v, updated %v, skipped %v; want everything up to date", res.Uploaded, res.Updated, res.Skipped)
+			}
+		})
+	}
+}
+
+func BenchmarkHashCandidates(b *testing.B) {
+	dir := b.TempDir()
+	content := make([]byte, 64<<10)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	for i := 0; i < 256; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("d%02d", i%16), fmt.Sprintf("f%03d.bin", i))
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := os.WriteFile(name, content, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	walker := BCDNSyncer{Logger: discardLogger{}}
+	candidates, err := walker.collectLocalFiles([]string{dir}, "", &syncMetrics{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	all := func(candidate) bool { return true }
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			s := BCDNSyncer{Concurrency: workers, Logger: discardLogger{}}
+			b.SetBytes(int64(len(candidates) * len(content)))
+			for b.Loop() {
+				for _, r := range s.hashCandidates(b.Context(), candidates, all) {
+					if r.err != nil {
+						b.Fatal(r.err)
+					}
+				}
+			}
+		})
+	}
+}
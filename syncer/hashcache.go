@@ -0,0 +1,89 @@
+package syncer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checksumCache remembers the SHA256 of local files by absolute path, size
+// and mtime, so unchanged files are not read again on the next run.
+type checksumCache struct {
+	mu      sync.Mutex
+	path    string
+	Entries map[string]checkpointEntry `json:"entries"`
+	dirty   bool
+}
+
+func loadChecksumCache(path string) (*checksumCache, error) {
+	c := &checksumCache{path: path, Entries: map[string]checkpointEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Entries == nil {
+		c.Entries = map[string]checkpointEntry{}
+	}
+	return c, nil
+}
+
+func (c *checksumCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(c)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+// fileChecksum returns the checksum the file will be stored with, from the
+// cache when its size and mtime are unchanged.
+func (s *BCDNSyncer) fileChecksum(name string, size int64, modTime time.Time) (string, error) {
+	c := s.hashCache
+	if c == nil || s.sourceFS != nil {
+		return s.storedChecksum(name)
+	}
+	key, err := filepath.Abs(name)
+	if err != nil {
+		key = name
+	}
+	// Compressed checksums are kept apart so toggling Compress never picks
+	// up a checksum of the other form.
+	if s.compressible(name) {
+		key = "gzip:" + key
+	}
+	c.mu.Lock()
+	e, ok := c.Entries[key]
+	c.mu.Unlock()
+	if ok && e.Size == size && e.ModTime == modTime.UnixNano() && e.Checksum != "" {
+		return e.Checksum, nil
+	}
+
+	checksum, err := s.storedChecksum(name)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.Entries[key] = checkpointEntry{Size: size, ModTime: modTime.UnixNano(), Checksum: checksum}
+	c.dirty = true
+	c.mu.Unlock()
+	return checksum, nil
+}
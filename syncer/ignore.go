@@ -0,0 +1,168 @@
+package syncer
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+const ignoreFileName = ".bunnyignore"
+
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreRules holds the .bunnyignore files of a source tree. Files are read
+// lazily the first time a path below their directory is checked, so the same
+// rules apply to the walk, git-diff syncs and delete decisions.
+type ignoreRules struct {
+	fsys  fs.FS
+	byDir map[string][]ignoreRule
+	log   api.Logger
+}
+
+func newIgnoreRules(fsys fs.FS, log api.Logger) *ignoreRules {
+	return &ignoreRules{fsys: fsys, byDir: map[string][]ignoreRule{}, log: log}
+}
+
+// parseIgnoreFile reads .gitignore syntax: blank lines and # comments are
+// skipped, ! negates, a trailing slash matches directories only and a slash
+// anywhere else anchors the pattern to the file's directory.
+func parseIgnoreFile(fsys fs.FS, name string) ([]ignoreRule, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		switch {
+		case strings.HasPrefix(line, "!"):
+			r.negate = true
+			line = line[1:]
+		case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+func (r *ignoreRules) rulesFor(dir string) []ignoreRule {
+	if rules, ok := r.byDir[dir]; ok {
+		return rules
+	}
+	rules, err := parseIgnoreFile(r.fsys, path.Join(dir, ignoreFileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		api.Warnf(r.log, "reading %s in %q: %v", ignoreFileName, dir, err)
+	}
+	r.byDir[dir] = rules
+	return rules
+}
+
+// ignored evaluates relPath against every .bunnyignore from the root down to
+// its parent directory; as in git, the last matching rule wins.
+func (r *ignoreRules) ignored(relPath string, isDir bool) bool {
+	if r == nil {
+		return false
+	}
+	result := false
+	dirs := []string{""}
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/"))
+	}
+	for _, dir := range dirs {
+		rel := relPath
+		if dir != "" {
+			rel = strings.TrimPrefix(relPath, dir+"/")
+		}
+		for _, rule := range r.rulesFor(dir) {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			var match bool
+			if rule.anchored {
+				match = matchSegments(strings.Split(rule.pattern, "/"), strings.Split(rel, "/"))
+			} else {
+				match, _ = path.Match(rule.pattern, path.Base(rel))
+			}
+			if match {
+				result = !rule.negate
+			}
+		}
+	}
+	return result
+}
+
+// ignoredPath also treats a file as ignored when one of its directories is,
+// for paths that were never reached by the walk such as remote objects.
+func (r *ignoreRules) ignoredPath(relPath string) bool {
+	if r == nil {
+		return false
+	}
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if r.ignored(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return r.ignored(relPath, false)
+}
+
+// ignoreSet merges the rules of several source roots: a path is ignored when
+// the .bunnyignore files of any source ignore it.
+type ignoreSet []*ignoreRules
+
+func newIgnoreSet(sources []string, log api.Logger) ignoreSet {
+	set := make(ignoreSet, 0, len(sources))
+	for _, sourcePath := range sources {
+		set = append(set, newIgnoreRules(os.DirFS(sourcePath), log))
+	}
+	return set
+}
+
+func (set ignoreSet) ignored(relPath string, isDir bool) bool {
+	for _, r := range set {
+		if r.ignored(relPath, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (set ignoreSet) ignoredPath(relPath string) bool {
+	for _, r := range set {
+		if r.ignoredPath(relPath) {
+			return true
+		}
+	}
+	return false
+}
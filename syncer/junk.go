@@ -0,0 +1,77 @@
+package syncer
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultExcludes lists OS and editor droppings that are never worth
+// uploading. Patterns match a single path component.
+var DefaultExcludes = []string{
+	// macOS
+	".DS_Store",
+	"._*",
+	".AppleDouble",
+	".LSOverride",
+	".Spotlight-V100",
+	".Trashes",
+	".fseventsd",
+	".TemporaryItems",
+	".DocumentRevisions-V100",
+	"Icon\r",
+	"__MACOSX",
+	// Windows
+	"Thumbs.db",
+	"ehthumbs.db",
+	"desktop.ini",
+	"$RECYCLE.BIN",
+	// Editors
+	"*~",
+	"*.swp",
+	"*.swo",
+	".#*",
+	"#*#",
+}
+
+func isJunkName(name string) bool {
+	for _, pattern := range DefaultExcludes {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// wellKnownDir holds ACME challenges and other files web clients look up,
+// so it is synced although its name starts with a dot.
+const wellKnownDir = ".well-known"
+
+func isHiddenName(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".." && name != wellKnownDir
+}
+
+// skipName reports whether a path component is junk or, without
+// IncludeHidden, a dotfile or dot-directory.
+func (s *BCDNSyncer) skipName(name string) bool {
+	return (!s.NoDefaultExcludes && isJunkName(name)) || (!s.IncludeHidden && isHiddenName(name))
+}
+
+func (s *BCDNSyncer) isJunk(relPath string) bool {
+	for _, part := range strings.Split(relPath, "/") {
+		if s.skipName(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// deletable reports whether a remote object, at relPath below the sync path
+// and without a local source, is a delete candidate. Staging directories
+// left behind by killed atomic runs always are, although their names start
+// with a dot.
+func (s *BCDNSyncer) deletable(relPath string) bool {
+	if top, _, _ := strings.Cut(relPath, "/"); strings.HasPrefix(top, stagingPrefix) {
+		return true
+	}
+	return !s.isJunk(relPath) && s.selected(relPath)
+}
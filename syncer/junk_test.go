@@ -0,0 +1,136 @@
+package syncer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+func TestIsJunk(t *testing.T) {
+	tests := []struct {
+		path          string
+		includeHidden bool
+		want          bool
+	}{
+		{"index.html", false, false},
+		{".git/config", false, true},
+		{"docs/.git/objects/ab/cdef", false, true},
+		{".well-known/acme-challenge/token", false, false},
+		{"css/.DS_Store", false, true},
+		{"notes.txt~", false, true},
+		{".htaccess", false, true},
+		{".htaccess", true, false},
+		{".git/config", true, false},
+		{"Thumbs.db", true, true},
+	}
+	for _, tt := range tests {
+		s := BCDNSyncer{IncludeHidden: tt.includeHidden}
+		if got := s.isJunk(tt.path); got != tt.want {
+			t.Errorf("isJunk(%q) with IncludeHidden=%v = %v, want %v", tt.path, tt.includeHidden, got, tt.want)
+		}
+	}
+}
+
+// The sync path itself may start with a dot; only the part below it is
+// checked for hidden names.
+func TestDeleteOrphansUnderHiddenSyncPath(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Path != "/zone/.staging/" {
+				fmt.Fprint(w, `[]`)
+				return
+			}
+			fmt.Fprint(w, `[
+				{"Path": "/zone/.staging/", "ObjectName": "old.txt", "Length": 3, "LastChanged": "2024-01-01T00:00:00"},
+				{"Path": "/zone/.staging/", "ObjectName": ".git", "IsDirectory": true, "LastChanged": "2024-01-01T00:00:00"}
+			]`)
+		case http.MethodDelete:
+			mu.Lock()
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/zone/"))
+			mu.Unlock()
+		case http.MethodPut:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := BCDNSyncer{
+		API:    api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+		Delete: true,
+		Logger: discardLogger{},
+	}
+	if _, err := s.Run(t.Context(), []string{dir}, ".staging"); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != ".staging/old.txt" {
+		t.Errorf("deleted %v, want [.staging/old.txt]", deleted)
+	}
+}
+
+// A staging directory left by a killed atomic run is deleted like any other
+// orphan; other hidden objects are still left alone.
+func TestDeleteOrphansRemovesStaleStaging(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimLeft(strings.TrimPrefix(r.URL.Path, "/zone"), "/")
+		switch r.Method {
+		case http.MethodGet:
+			switch name {
+			case "site/":
+				fmt.Fprint(w, `[
+					{"Path": "/zone/site/", "ObjectName": ".staging-20240101T000000", "IsDirectory": true},
+					{"Path": "/zone/site/", "ObjectName": ".git", "IsDirectory": true},
+					{"Path": "/zone/site/", "ObjectName": "old.txt", "Length": 3}
+				]`)
+			case "site/.staging-20240101T000000/":
+				fmt.Fprint(w, `[{"Path": "/zone/site/.staging-20240101T000000/", "ObjectName": "index.html", "Length": 3}]`)
+			case "site/.git/":
+				fmt.Fprint(w, `[{"Path": "/zone/site/.git/", "ObjectName": "config", "Length": 3}]`)
+			default:
+				fmt.Fprint(w, `[]`)
+			}
+		case http.MethodDelete:
+			mu.Lock()
+			deleted = append(deleted, name)
+			mu.Unlock()
+		case http.MethodPut:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := BCDNSyncer{
+		API:              api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+		Delete:           true,
+		MaxDeletePercent: 100,
+		Include:          []string{"*.html"},
+		Logger:           discardLogger{},
+	}
+	if _, err := s.Run(t.Context(), []string{dir}, "site"); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(deleted)
+	if want := []string{"site/.staging-20240101T000000/index.html"}; strings.Join(deleted, ",") != strings.Join(want, ",") {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+}
@@ -0,0 +1,38 @@
+package syncer
+
+import "github.com/veter2005/bunny-storage-sync/api"
+
+// KeyStrategy maps both sides of a sync into one comparison key space.
+// ObjectKey derives the key for a listed remote object and RemotePath
+// derives it for a local file; RemotePath is also the upload destination.
+// Zones populated by other tools can plug in their own conventions.
+type KeyStrategy interface {
+	ObjectKey(zoneName string, obj api.BCDNObject) string
+	RemotePath(syncPath, relPath string) string
+}
+
+type DefaultKeyStrategy struct{}
+
+func (DefaultKeyStrategy) ObjectKey(zoneName string, obj api.BCDNObject) string {
+	return objectPath(zoneName, obj)
+}
+
+func (DefaultKeyStrategy) RemotePath(syncPath, relPath string) string {
+	if syncPath == "" {
+		return relPath
+	}
+	return syncPath + "/" + relPath
+}
+
+func (s *BCDNSyncer) keys() KeyStrategy {
+	if s.Keys != nil {
+		return s.Keys
+	}
+	return DefaultKeyStrategy{}
+}
+
+// objectPath is the zone-relative path the storage API addresses obj by,
+// independent of the configured KeyStrategy.
+func objectPath(zoneName string, obj api.BCDNObject) string {
+	return api.ObjectPath(zoneName, obj)
+}
@@ -0,0 +1,351 @@
+package syncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+const ManifestName = ".bunny-manifest.json"
+
+type manifestEntry struct {
+	Path        string    `json:"path"`
+	Length      int       `json:"length"`
+	Checksum    string    `json:"checksum"`
+	LastChanged time.Time `json:"lastChanged"`
+	// ModTime is the mtime of the local file the object was uploaded from.
+	ModTime time.Time `json:"mtime,omitzero"`
+}
+
+type zoneManifest struct {
+	Generated time.Time       `json:"generated"`
+	SyncPath  string          `json:"syncPath"`
+	Objects   []manifestEntry `json:"objects"`
+}
+
+// manifestState tracks what the zone holds during a run so the manifest
+// written at the end reflects every upload and delete that succeeded.
+type manifestState struct {
+	mu      sync.Mutex
+	entries map[string]manifestEntry
+	dirty   bool
+}
+
+func manifestPath(syncPath string) string {
+	return path.Join(syncPath, ManifestName)
+}
+
+// newManifestState starts from the remote objects. Upload mtimes are taken
+// from the previous manifest for objects that still match it.
+func newManifestState(zoneName string, objMap map[string]api.BCDNObject, previous map[string]manifestEntry) *manifestState {
+	m := &manifestState{entries: make(map[string]manifestEntry, len(objMap))}
+	for _, obj := range objMap {
+		p := objectPath(zoneName, obj)
+		if p == syncMarkerName {
+			continue
+		}
+		e := manifestEntry{Path: p, Length: obj.Length, Checksum: obj.Checksum, LastChanged: obj.LastChanged.Time}
+		if prev, ok := previous[p]; ok && prev.matches(obj) {
+			e.ModTime = prev.ModTime
+		}
+		m.entries[p] = e
+	}
+	return m
+}
+
+func (e manifestEntry) matches(obj api.BCDNObject) bool {
+	return e.Length == obj.Length && strings.EqualFold(e.Checksum, obj.Checksum)
+}
+
+func (m *zoneManifest) entries() map[string]manifestEntry {
+	if m == nil {
+		return nil
+	}
+	entries := make(map[string]manifestEntry, len(m.Objects))
+	for _, e := range m.Objects {
+		entries[e.Path] = e
+	}
+	return entries
+}
+
+func (m *manifestState) put(p string, length int64, checksum string, modTime time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dirty = true
+	m.entries[p] = manifestEntry{Path: p, Length: int(length), Checksum: strings.ToUpper(checksum), LastChanged: time.Now().UTC(), ModTime: modTime}
+}
+
+// unchanged reports whether p was last uploaded from a file with this size
+// and mtime, in which case the file need not be read to compare it.
+func (m *manifestState) unchanged(p string, size int64, modTime time.Time) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[p]
+	return ok && !e.ModTime.IsZero() && e.ModTime.Equal(modTime) && int64(e.Length) == size
+}
+
+func (m *manifestState) remove(p string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dirty = true
+	delete(m.entries, p)
+}
+
+// manifestUnchanged reports whether the manifest shows c uploaded unchanged.
+// Compressed files are stored with a different length and always compared.
+func (s *BCDNSyncer) manifestUnchanged(c candidate) bool {
+	return !s.DetectDrift && !s.compressible(c.path) && s.manifest.unchanged(c.relPath, c.info.Size(), c.info.ModTime())
+}
+
+// uploadedUnchanged reports whether the previous manifest shows the local
+// file at relPath uploaded as it is now, by checksum when one is given and
+// otherwise by size and mtime. It is only used by DetectDrift.
+func (s *BCDNSyncer) uploadedUnchanged(relPath, checksum string, info os.FileInfo) bool {
+	if !s.DetectDrift {
+		return false
+	}
+	e, ok := s.uploaded[relPath]
+	switch {
+	case !ok:
+		return false
+	case checksum != "":
+		return strings.EqualFold(e.Checksum, checksum)
+	}
+	return !e.ModTime.IsZero() && e.ModTime.Equal(info.ModTime()) && int64(e.Length) == info.Size()
+}
+
+// trustedUnchanged reports whether, with TrustCache, the previous manifest
+// shows c uploaded from a file of its current size and mtime and obj still
+// holds that upload. Without a checksum in the listing it is never trusted.
+func (s *BCDNSyncer) trustedUnchanged(c candidate, obj api.BCDNObject) bool {
+	if !s.TrustCache || obj.Checksum == "" || s.compressible(c.path) {
+		return false
+	}
+	e, ok := s.uploaded[c.relPath]
+	return ok && e.matches(obj) && !e.ModTime.IsZero() && e.ModTime.Equal(c.info.ModTime()) && int64(e.Length) == c.info.Size()
+}
+
+// loadRemoteObjects returns the remote side of the comparison, from the zone
+// manifest when one is usable and from a full recursive listing otherwise.
+func (s *BCDNSyncer) loadRemoteObjects(ctx context.Context, syncPath string) (map[string]api.BCDNObject, error) {
+	var objMap map[string]api.BCDNObject
+	var previous map[string]manifestEntry
+	fromManifest := false
+	switch {
+	case s.UseManifest && s.FullList, s.DetectDrift, s.TrustCache && !s.UseManifest:
+		m, err := s.readManifest(ctx, syncPath, "")
+		if err != nil && !errors.Is(err, api.ErrNotFound) {
+			s.warnf("previous zone manifest unreadable: %v", err)
+		}
+		previous = m.entries()
+	case s.UseManifest:
+		var m *zoneManifest
+		var err error
+		objMap, m, err = s.fetchManifest(ctx, syncPath)
+		if err != nil {
+			s.warnf("zone manifest unusable, listing the zone: %v", err)
+		}
+		previous = m.entries()
+		fromManifest = objMap != nil
+	}
+	s.fromManifest = fromManifest
+	if objMap == nil {
+		s.logger().Infof("Fetching remote objects (parallel scan)...")
+		var err error
+		objMap, s.listedDirs, err = s.fetchObjectsAndDirs(ctx, syncPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.DetectDrift || s.TrustCache {
+		switch {
+		case previous != nil:
+		case s.DetectDrift:
+			s.warnf("no zone manifest to compare against; files changed only in the zone are reported as changed, not as drift")
+		default:
+			s.warnf("no zone manifest to trust; unchanged files are read and compared by checksum")
+		}
+		s.uploaded = previous
+		delete(objMap, manifestPath(syncPath))
+	}
+	if s.UseManifest {
+		delete(objMap, manifestPath(syncPath))
+		if !fromManifest {
+			s.reportDrift(objMap, previous)
+		}
+		s.manifest = newManifestState(s.API.ZoneName, objMap, previous)
+		s.manifest.dirty = !fromManifest
+	}
+	return objMap, nil
+}
+
+// fetchManifest reads the manifest with a single listing of syncPath and one
+// GET. The listing doubles as a staleness check: when the files directly in
+// syncPath or its top-level directories disagree with the manifest, the zone
+// was changed by someone else and a nil map is returned so the caller
+// re-lists. The manifest itself is returned whenever it could be read.
+func (s *BCDNSyncer) fetchManifest(ctx context.Context, syncPath string) (map[string]api.BCDNObject, *zoneManifest, error) {
+	root, err := s.API.ListContext(ctx, syncPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifestObj *api.BCDNObject
+	rootFiles := map[string]api.BCDNObject{}
+	rootDirs := map[string]bool{}
+	for i, obj := range root {
+		p := objectPath(s.API.ZoneName, obj)
+		switch {
+		case obj.IsDirectory:
+			rootDirs[p] = true
+		case p == manifestPath(syncPath):
+			manifestObj = &root[i]
+		case p != syncMarkerName:
+			rootFiles[p] = obj
+		}
+	}
+	if manifestObj == nil {
+		s.logger().Infof("No zone manifest found yet, listing the zone")
+		return nil, nil, nil
+	}
+
+	m, err := s.readManifest(ctx, syncPath, manifestObj.Checksum)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objMap := make(map[string]api.BCDNObject, len(m.Objects))
+	seenFiles, seenDirs := 0, map[string]bool{}
+	for _, e := range m.Objects {
+		dir := remoteDir(e.Path)
+		if dir == syncPath {
+			obj, ok := rootFiles[e.Path]
+			if !ok || obj.Length != e.Length || !strings.EqualFold(obj.Checksum, e.Checksum) {
+				s.logger().Infof("Zone manifest is stale (%s changed), listing the zone", e.Path)
+				return nil, m, nil
+			}
+			seenFiles++
+		} else {
+			top := strings.TrimPrefix(e.Path, syncPath+"/")
+			if syncPath == "" {
+				top = e.Path
+			}
+			seenDirs[path.Join(syncPath, strings.SplitN(top, "/", 2)[0])] = true
+		}
+
+		objectDir := "/" + s.API.ZoneName + "/"
+		if dir != "" {
+			objectDir += dir + "/"
+		}
+		obj := api.BCDNObject{
+			StorageZoneName: s.API.ZoneName,
+			Path:            objectDir,
+			ObjectName:      path.Base(e.Path),
+			Length:          e.Length,
+			Checksum:        e.Checksum,
+			LastChanged:     api.BCDNTime{Time: e.LastChanged},
+		}
+		objMap[s.keys().ObjectKey(s.API.ZoneName, obj)] = obj
+	}
+	if seenFiles != len(rootFiles) {
+		s.logger().Infof("Zone manifest is stale (files were added to %q), listing the zone", syncPath)
+		return nil, m, nil
+	}
+	for dir := range seenDirs {
+		if !rootDirs[dir] {
+			s.logger().Infof("Zone manifest is stale (%s is gone), listing the zone", dir)
+			return nil, m, nil
+		}
+	}
+
+	s.logger().Infof("Using zone manifest from %s", m.Generated.Format(time.RFC3339))
+	return objMap, m, nil
+}
+
+// readManifest downloads and parses the manifest of syncPath; checksum is
+// the one listed for it, if known.
+func (s *BCDNSyncer) readManifest(ctx context.Context, syncPath, checksum string) (*zoneManifest, error) {
+	content, err := s.API.GetContext(ctx, manifestPath(syncPath))
+	if err != nil {
+		return nil, err
+	}
+	if checksum != "" && !strings.EqualFold(checksum, fmt.Sprintf("%x", sha256.Sum256([]byte(content)))) {
+		return nil, fmt.Errorf("%s changed while it was read", ManifestName)
+	}
+	var m zoneManifest
+	if err := json.Unmarshal([]byte(content), &m); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ManifestName, err)
+	}
+	if m.SyncPath != syncPath {
+		return nil, fmt.Errorf("%s was written for path %q", ManifestName, m.SyncPath)
+	}
+	return &m, nil
+}
+
+// reportDrift warns about objects a listing shows changed or removed since
+// the previous manifest was written, i.e. outside of a manifest sync.
+func (s *BCDNSyncer) reportDrift(objMap map[string]api.BCDNObject, previous map[string]manifestEntry) {
+	if previous == nil {
+		return
+	}
+	listed := make(map[string]bool, len(objMap))
+	for _, obj := range objMap {
+		p := objectPath(s.API.ZoneName, obj)
+		listed[p] = true
+		if prev, ok := previous[p]; ok && !prev.matches(obj) {
+			s.warnf("%s changed in the zone since the manifest was written", p)
+		}
+	}
+	for p := range previous {
+		if !listed[p] {
+			s.warnf("%s was removed from the zone since the manifest was written", p)
+		}
+	}
+}
+
+func (s *BCDNSyncer) writeManifest(ctx context.Context, syncPath string, metrics *syncMetrics) {
+	if s.manifest == nil || !s.manifest.dirty {
+		return
+	}
+	if s.DryRun {
+		s.logger().Infof("DRY-RUN: Would write %s", manifestPath(syncPath))
+		return
+	}
+
+	s.manifest.mu.Lock()
+	m := zoneManifest{Generated: time.Now().UTC(), SyncPath: syncPath, Objects: make([]manifestEntry, 0, len(s.manifest.entries))}
+	for _, e := range s.manifest.entries {
+		m.Objects = append(m.Objects, e)
+	}
+	s.manifest.mu.Unlock()
+	sort.Slice(m.Objects, func(i, j int) bool { return m.Objects[i].Path < m.Objects[j].Path })
+
+	content, err := json.Marshal(m)
+	if err != nil {
+		s.logger().Errorf("building %s: %v", ManifestName, err)
+		return
+	}
+	checksum := fmt.Sprintf("%x", sha256.Sum256(content))
+	if err := s.API.UploadContext(ctx, manifestPath(syncPath), content, checksum); err != nil {
+		s.logger().Errorf("writing %s: %v", ManifestName, err)
+		metrics.fail("manifest", manifestPath(syncPath), err)
+	}
+}
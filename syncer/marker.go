@@ -0,0 +1,52 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+const syncMarkerName = ".last-sync.json"
+
+type syncMarker struct {
+	Timestamp time.Time `json:"timestamp"`
+	Version   string    `json:"version"`
+	Host      string    `json:"host"`
+	SyncPath  string    `json:"syncPath"`
+	Total     int       `json:"total"`
+	New       int       `json:"new"`
+	Updated   int       `json:"updated"`
+	Deleted   int       `json:"deleted"`
+	Skipped   int       `json:"skipped"`
+}
+
+func (s *BCDNSyncer) writeSyncMarker(ctx context.Context, syncPath string, m *syncMetrics) {
+	if s.DryRun {
+		s.logger().Infof("DRY-RUN: Would write %s", syncMarkerName)
+		return
+	}
+	host, _ := os.Hostname()
+	sum := m.summary()
+	marker := syncMarker{
+		Timestamp: time.Now().UTC(),
+		Version:   s.Version,
+		Host:      host,
+		SyncPath:  syncPath,
+		Total:     sum.Total,
+		New:       sum.New,
+		Updated:   sum.Updated,
+		Deleted:   sum.Deleted,
+		Skipped:   sum.Skipped,
+	}
+
+	content, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		s.logger().Errorf("building sync marker: %v", err)
+		return
+	}
+	if err := s.API.UploadContext(ctx, syncMarkerName, content, ""); err != nil {
+		s.logger().Errorf("writing sync marker: %v", err)
+		m.fail("marker", syncMarkerName, err)
+	}
+}
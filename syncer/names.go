@@ -0,0 +1,73 @@
+package syncer
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+const (
+	SanitizeWarn    = "warn"
+	SanitizeError   = "error"
+	SanitizeSkip    = "skip"
+	SanitizeRewrite = "rewrite"
+)
+
+var reservedWindowsNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+const invalidWindowsChars = `<>:"\|?*`
+
+// nameProblem explains why a slash-separated path cannot round-trip through
+// a Windows filesystem, or returns "" if it can.
+func nameProblem(relPath string) string {
+	for _, seg := range strings.Split(relPath, "/") {
+		base, _, _ := strings.Cut(seg, ".")
+		switch {
+		case reservedWindowsNames[strings.ToUpper(strings.TrimRight(base, " "))]:
+			return "reserved Windows name " + seg
+		case strings.HasSuffix(seg, ".") || strings.HasSuffix(seg, " "):
+			return "trailing dot or space in " + seg
+		case strings.ContainsAny(seg, invalidWindowsChars) || strings.IndexFunc(seg, func(r rune) bool { return r < 0x20 }) >= 0:
+			return "invalid Windows character in " + seg
+		}
+	}
+	return ""
+}
+
+func sanitizeName(relPath string) string {
+	segs := strings.Split(relPath, "/")
+	for i, seg := range segs {
+		seg = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(invalidWindowsChars, r) {
+				return '_'
+			}
+			return r
+		}, seg)
+		if trimmed := strings.TrimRight(seg, ". "); trimmed != seg {
+			seg = trimmed + strings.Repeat("_", len(seg)-len(trimmed))
+		}
+		base, ext, hasExt := strings.Cut(seg, ".")
+		if reservedWindowsNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+			seg = base + "_"
+			if hasExt {
+				seg += "." + ext
+			}
+		}
+		segs[i] = seg
+	}
+	return strings.Join(segs, "/")
+}
+
+func writeSanitizeMap(file string, renamed map[string]string) error {
+	data, err := json.MarshalIndent(renamed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
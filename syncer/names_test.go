@@ -0,0 +1,42 @@
+package syncer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeNames(t *testing.T) {
+	tests := []struct {
+		path    string
+		problem string
+		want    string
+	}{
+		{"docs/readme.txt", "", "docs/readme.txt"},
+		{"CON", "reserved Windows name CON", "CON_"},
+		{"prn.txt", "reserved Windows name prn.txt", "prn_.txt"},
+		{"dir/aux.tar.gz", "reserved Windows name aux.tar.gz", "dir/aux_.tar.gz"},
+		{"COM1 .log", "reserved Windows name COM1 .log", "COM1 _.log"},
+		{"LPT10.txt", "", "LPT10.txt"},
+		{"console.txt", "", "console.txt"},
+		{"notes.", "trailing dot or space in notes.", "notes_"},
+		{"dir./file.txt", "trailing dot or space in dir.", "dir_/file.txt"},
+		{"name . ", "trailing dot or space in name . ", "name___"},
+		{"a:b?.txt", "invalid Windows character in a:b?.txt", "a_b_.txt"},
+		{"tab\there", "invalid Windows character in tab\there", "tab_here"},
+	}
+	for _, tt := range tests {
+		if got := nameProblem(tt.path); got != tt.problem {
+			t.Errorf("nameProblem(%q) = %q, want %q", tt.path, got, tt.problem)
+		}
+		got := sanitizeName(tt.path)
+		if got != tt.want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+		if problem := nameProblem(got); problem != "" {
+			t.Errorf("sanitizeName(%q) = %q still has a problem: %s", tt.path, got, problem)
+		}
+		if strings.Count(got, "/") != strings.Count(tt.path, "/") {
+			t.Errorf("sanitizeName(%q) = %q changed the directory depth", tt.path, got)
+		}
+	}
+}
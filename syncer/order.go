@@ -0,0 +1,28 @@
+package syncer
+
+import (
+	"sort"
+	"strings"
+)
+
+// shallowerFirst orders paths by depth, then by name, so the files of a
+// directory come before those of its subdirectories.
+func shallowerFirst(a, b string) bool {
+	if da, db := strings.Count(a, "/"), strings.Count(b, "/"); da != db {
+		return da < db
+	}
+	return a < b
+}
+
+// sortUploads puts operations in Sorted order.
+func (s *BCDNSyncer) sortUploads(operations []operation) {
+	if s.Sorted {
+		sort.SliceStable(operations, func(i, j int) bool { return shallowerFirst(operations[i].relPath, operations[j].relPath) })
+	}
+}
+
+func (s *BCDNSyncer) sortPaths(paths []string) {
+	if s.Sorted {
+		sort.SliceStable(paths, func(i, j int) bool { return shallowerFirst(paths[i], paths[j]) })
+	}
+}
@@ -0,0 +1,134 @@
+package syncer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoadPathMap reads a local-to-remote path mapping. Files ending in .json
+// hold a single object of "local": "remote" pairs; anything else is read as
+// two-column CSV. Both sides are slash-separated and relative (the local side
+// to the source directory, the remote side to the sync path).
+func LoadPathMap(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	raw := map[string]string{}
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		if err := json.NewDecoder(f).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("invalid map file %s: %w", file, err)
+		}
+	} else {
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = 2
+		r.Comment = '#'
+		r.TrimLeadingSpace = true
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid map file %s: %w", file, err)
+			}
+			if _, dup := raw[record[0]]; dup {
+				return nil, fmt.Errorf("invalid map file %s: %q is mapped more than once", file, record[0])
+			}
+			raw[record[0]] = record[1]
+		}
+	}
+
+	pathMap := make(map[string]string, len(raw))
+	for local, remote := range raw {
+		local = strings.Trim(filepath.ToSlash(filepath.Clean(local)), "/")
+		remote = strings.Trim(remote, "/")
+		if local == "" || local == "." || remote == "" {
+			return nil, fmt.Errorf("invalid map file %s: empty path in mapping %q -> %q", file, local, remote)
+		}
+		pathMap[local] = remote
+	}
+	return pathMap, nil
+}
+
+func (s *BCDNSyncer) validatePathMap(sources []string) error {
+	var problems []string
+	targets := map[string]string{}
+	for local, remote := range s.PathMap {
+		if other, ok := targets[remote]; ok && s.OnDuplicate != DuplicateLastWins {
+			problems = append(problems, fmt.Sprintf("%s and %s both map to %s", other, local, remote))
+		}
+		targets[remote] = local
+		if !s.existsInAny(sources, local) {
+			problems = append(problems, fmt.Sprintf("%s does not exist locally", local))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid path map: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (s *BCDNSyncer) existsInAny(sources []string, relPath string) bool {
+	for _, sourcePath := range sources {
+		if _, err := s.statLocal(s.localPath(sourcePath, relPath)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *BCDNSyncer) remotePath(syncPath, relPath string) string {
+	if mapped, ok := s.PathMap[relPath]; ok {
+		relPath = mapped
+	} else {
+		relPath = strings.TrimPrefix(relPath, s.prefix)
+	}
+	return s.keys().RemotePath(syncPath, relPath)
+}
+
+// cleanStripPrefix normalizes StripPrefix to a relative path ending in a
+// slash, or "" for none.
+func cleanStripPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	p := strings.Trim(path.Clean(filepath.ToSlash(prefix)), "/")
+	switch {
+	case p == "" || p == ".":
+		return "", nil
+	case p == ".." || strings.HasPrefix(p, "../"):
+		return "", fmt.Errorf("strip prefix %q points outside the source", prefix)
+	}
+	return p + "/", nil
+}
+
+// underPrefix reports whether the local file localRel is synced with
+// StripPrefix set: it lies below the prefix or has a PathMap entry.
+func (s *BCDNSyncer) underPrefix(localRel string) bool {
+	if s.prefix == "" || strings.HasPrefix(localRel, s.prefix) {
+		return true
+	}
+	_, mapped := s.PathMap[localRel]
+	return mapped
+}
+
+// outsidePrefix reports whether the walk can skip dir because no file below
+// it is under StripPrefix. With a PathMap every directory is walked.
+func (s *BCDNSyncer) outsidePrefix(dir string) bool {
+	if s.prefix == "" || len(s.PathMap) > 0 {
+		return false
+	}
+	d := dir + "/"
+	return !strings.HasPrefix(d, s.prefix) && !strings.HasPrefix(s.prefix, d)
+}
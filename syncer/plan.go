@@ -0,0 +1,138 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// SyncPlan lists the uploads, updates and deletes a push would make, as
+// decided by Plan. Operations may be filtered before the plan is passed to
+// Apply, which runs only the operations still listed.
+type SyncPlan struct {
+	Operations []ReportOperation
+
+	sources     []string
+	syncPath    string
+	objMap      map[string]api.BCDNObject
+	remoteCount int
+	localFiles  []localFile
+	uploads     []operation
+	metrics     *syncMetrics
+	cp          *checkpoint
+	applied     bool
+}
+
+// Plan lists and compares like Sync but changes nothing in the zone.
+func (s *BCDNSyncer) Plan(sourcePath, syncPath string) (*SyncPlan, error) {
+	return s.PlanSourcesContext(context.Background(), []string{sourcePath}, syncPath)
+}
+
+// PlanSourcesContext is Plan for several merged sources, see SyncSources.
+// Pull, git diff and prune-only runs cannot be planned, and an interrupted
+// delete phase recorded in DeleteCheckpoint is only resumed by Sync.
+func (s *BCDNSyncer) PlanSourcesContext(ctx context.Context, sources []string, syncPath string) (*SyncPlan, error) {
+	s.started = time.Now()
+	var plan *SyncPlan
+	err := s.withLimits(ctx, func(ctx context.Context) error {
+		syncPath, err := s.prepare(sources, syncPath)
+		if err != nil {
+			return err
+		}
+		if s.Direction == DirectionPull || s.GitDiff != "" || s.PruneOnly {
+			return errors.New("only push syncs can be planned")
+		}
+		if err := s.API.CheckAccessContext(ctx); err != nil {
+			return err
+		}
+		p, candidates, err := s.scan(ctx, sources, syncPath)
+		if err != nil {
+			return err
+		}
+		defer s.saveChecksumCache()
+		if err := s.classify(ctx, p, candidates); err != nil {
+			return err
+		}
+		plan = p
+		return nil
+	})
+	return plan, err
+}
+
+// Apply runs a plan returned by the last Plan call of s, followed by the
+// index, sitemap, manifest, purge and marker steps the settings ask for.
+func (s *BCDNSyncer) Apply(plan *SyncPlan) error {
+	return s.ApplyContext(context.Background(), plan)
+}
+
+func (s *BCDNSyncer) ApplyContext(ctx context.Context, plan *SyncPlan) error {
+	if plan == nil || plan != s.lastPlan {
+		return errors.New("plan was not made by the last Plan call of this syncer")
+	}
+	return s.withLimits(ctx, func(ctx context.Context) error {
+		if s.hashCache != nil {
+			defer s.saveChecksumCache()
+		}
+		return s.apply(ctx, plan)
+	})
+}
+
+// planOperations lists the uploads of plan and, with Delete, the remote
+// files no source has. Objects the index and sitemap steps regenerate are
+// left out, as Apply never deletes them.
+func (s *BCDNSyncer) planOperations(plan *SyncPlan) []ReportOperation {
+	ops := make([]ReportOperation, 0, len(plan.uploads))
+	for _, o := range plan.uploads {
+		ops = append(ops, o.report())
+	}
+	if !s.Delete {
+		return ops
+	}
+
+	generated := s.generatedPaths(plan.syncPath, plan.localFiles)
+	var deletes []ReportOperation
+	for key, o := range plan.objMap {
+		p := objectPath(s.API.ZoneName, o)
+		rel := p
+		if plan.syncPath != "" {
+			rel = strings.TrimPrefix(p, plan.syncPath+"/")
+		}
+		if o.IsDirectory || generated[key] || !s.deletable(rel) {
+			continue
+		}
+		deletes = append(deletes, ReportOperation{Action: "delete", Path: p, Size: int64(o.Length), Reason: s.deleteReason(p)})
+	}
+	sort.Slice(deletes, func(i, j int) bool { return deletes[i].Path < deletes[j].Path })
+	return append(ops, deletes...)
+}
+
+// generatedPaths returns the objects syncDirectoryIndexes and syncSitemap
+// will write for files.
+func (s *BCDNSyncer) generatedPaths(syncPath string, files []localFile) map[string]bool {
+	paths := map[string]bool{}
+	if s.GenerateIndex {
+		paths[path.Join(syncPath, indexFileName)] = true
+		for _, f := range files {
+			for dir := remoteDir(f.relPath); dir != syncPath && dir != ""; dir = remoteDir(dir) {
+				paths[path.Join(dir, indexFileName)] = true
+			}
+		}
+	}
+	if s.GenerateSitemap {
+		paths[path.Join(syncPath, sitemapFileName)] = true
+	}
+	return paths
+}
+
+func (p *SyncPlan) approved() map[string]bool {
+	approved := make(map[string]bool, len(p.Operations))
+	for _, o := range p.Operations {
+		approved[o.Action+" "+o.Path] = true
+	}
+	return approved
+}
@@ -0,0 +1,55 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// probeCandidates stands in for the zone listing with ProbeMissing: each
+// candidate is looked up with its own request, and the objects found are
+// returned keyed like a listing. A candidate whose probe fails is excluded,
+// so it is neither uploaded nor taken as missing.
+func (s *BCDNSyncer) probeCandidates(ctx context.Context, candidates []candidate, metrics *syncMetrics) map[string]api.BCDNObject {
+	s.logger().Infof("Probing the zone for %d local files...", len(candidates))
+	objMap := map[string]api.BCDNObject{}
+	var mu sync.Mutex
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < s.workers(s.ListConcurrency); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c := &candidates[i]
+				if ctx.Err() != nil {
+					c.excluded = true
+					continue
+				}
+				obj, err := s.API.StatContext(ctx, c.relPath)
+				switch {
+				case err == nil:
+					mu.Lock()
+					objMap[c.relPath] = obj
+					mu.Unlock()
+				case errors.Is(err, api.ErrNotFound):
+				default:
+					s.logger().Errorf("probing %s: %v", c.relPath, err)
+					metrics.fail("probe", c.relPath, err)
+					c.excluded = true
+				}
+			}
+		}()
+	}
+	for i, c := range candidates {
+		if !c.excluded && !c.notModified {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	s.logger().Infof("Found %d of them in the zone", len(objMap))
+	return objMap
+}
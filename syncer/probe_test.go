@@ -0,0 +1,79 @@
+package syncer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// BenchmarkProbeVersusListing compares looking up a few local files one by
+// one with listing a zone that holds far more. Every request costs a
+// millisecond, standing in for the round trip to the storage API.
+func BenchmarkProbeVersusListing(b *testing.B) {
+	const dirs, perDir, local = 50, 200, 20
+	listings := map[string]string{}
+	var root []string
+	for d := 0; d < dirs; d++ {
+		root = append(root, fmt.Sprintf(`{"Path": "/zone/", "ObjectName": "d%02d", "IsDirectory": true}`, d))
+		var entries []string
+		for f := 0; f < perDir; f++ {
+			entries = append(entries, fmt.Sprintf(`{"Path": "/zone/d%02d/", "ObjectName": "f%03d.html", "Length": 10, "Checksum": "%064X"}`, d, f, f))
+		}
+		listings[fmt.Sprintf("d%02d/", d)] = "[" + strings.Join(entries, ",") + "]"
+	}
+	listings[""] = "[" + strings.Join(root, ",") + "]"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		name := strings.TrimLeft(strings.TrimPrefix(r.URL.Path, "/zone"), "/")
+		if r.Method == "DESCRIBE" {
+			var d, f int
+			if _, err := fmt.Sscanf(name, "d%02d/f%03d.html", &d, &f); err != nil || d >= dirs || f >= perDir {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `{"Path": "/zone/d%02d/", "ObjectName": "f%03d.html", "Length": 10}`, d, f)
+			return
+		}
+		listing, ok := listings[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, listing)
+	}))
+	defer srv.Close()
+
+	candidates := make([]candidate, local)
+	for i := range candidates {
+		candidates[i].relPath = fmt.Sprintf("d%02d/f%03d.html", i, i)
+	}
+	s := BCDNSyncer{
+		API:         api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+		Concurrency: 5,
+		Logger:      discardLogger{},
+	}
+
+	b.Run("probe", func(b *testing.B) {
+		for b.Loop() {
+			if objMap := s.probeCandidates(b.Context(), append([]candidate(nil), candidates...), &syncMetrics{}); len(objMap) != local {
+				b.Fatalf("probed %d objects, want %d", len(objMap), local)
+			}
+		}
+	})
+	b.Run("listing", func(b *testing.B) {
+		for b.Loop() {
+			objMap, err := s.loadRemoteObjects(b.Context(), "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(objMap) != dirs*perDir {
+				b.Fatalf("listed %d objects, want %d", len(objMap), dirs*perDir)
+			}
+		}
+	})
+}
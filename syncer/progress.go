@@ -0,0 +1,103 @@
+package syncer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+const (
+	throughputAlpha  = 0.3
+	throughputSample = time.Second
+)
+
+// progressTracker keeps an exponential moving average of upload throughput
+// so the ETA reacts to changing conditions without jumping on every burst.
+type progressTracker struct {
+	mu          sync.Mutex
+	totalFiles  int
+	totalBytes  int64
+	doneFiles   int
+	doneBytes   int64
+	rate        float64
+	sampleStart time.Time
+	sampleBytes int64
+}
+
+func newProgressTracker(operations []operation) *progressTracker {
+	p := &progressTracker{totalFiles: len(operations), sampleStart: time.Now()}
+	for _, op := range operations {
+		p.totalBytes += op.size
+	}
+	return p
+}
+
+func (p *progressTracker) complete(bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.doneFiles++
+	p.doneBytes += bytes
+	p.sampleBytes += bytes
+	p.sampleLocked(time.Now())
+}
+
+func (p *progressTracker) sampleLocked(now time.Time) {
+	elapsed := now.Sub(p.sampleStart)
+	if elapsed < throughputSample {
+		return
+	}
+	current := float64(p.sampleBytes) / elapsed.Seconds()
+	if p.rate == 0 {
+		p.rate = current
+	} else {
+		p.rate = throughputAlpha*current + (1-throughputAlpha)*p.rate
+	}
+	p.sampleStart = now
+	p.sampleBytes = 0
+}
+
+func (p *progressTracker) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sampleLocked(time.Now())
+
+	eta := "unknown"
+	if p.rate > 0 {
+		remaining := time.Duration(float64(p.totalBytes-p.doneBytes) / p.rate * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%d/%d files, %s/%s, %s/s, ETA %s",
+		p.doneFiles, p.totalFiles, formatBytes(p.doneBytes), formatBytes(p.totalBytes), formatBytes(int64(p.rate)), eta)
+}
+
+func (p *progressTracker) report(interval time.Duration, log api.Logger) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Infof("Progress: %s", p)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
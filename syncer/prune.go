@@ -0,0 +1,39 @@
+package syncer
+
+import (
+	"context"
+	"path"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// pruneOnly runs just the delete phase: the local walk is used only to tell
+// which remote objects still have a source, nothing is read or uploaded.
+func (s *BCDNSyncer) pruneOnly(ctx context.Context, sources []string, syncPath string, candidates []candidate, objMap map[string]api.BCDNObject, remoteCount int, metrics *syncMetrics) error {
+	for _, c := range candidates {
+		delete(objMap, c.relPath)
+	}
+	// Generated objects have no local source but must survive a prune when
+	// the run that would regenerate them is configured.
+	for key, obj := range objMap {
+		name := path.Base(objectPath(s.API.ZoneName, obj))
+		if (s.GenerateIndex && name == indexFileName) || (s.GenerateSitemap && name == sitemapFileName) {
+			delete(objMap, key)
+		}
+	}
+
+	if len(objMap) > 0 {
+		if err := s.deleteOrphans(ctx, sources, syncPath, objMap, remoteCount, metrics, nil); err != nil {
+			return err
+		}
+	}
+	if s.PruneEmptyDirs {
+		s.pruneEmptyDirs(ctx, syncPath, metrics)
+	}
+	s.writeManifest(ctx, syncPath, metrics)
+	if s.Purge {
+		s.purgeChanged(ctx, metrics)
+	}
+	s.printSummary(metrics)
+	return nil
+}
@@ -0,0 +1,346 @@
+package syncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+const (
+	DirectionPush = "push"
+	DirectionPull = "pull"
+)
+
+var errSpaceUnknown = errors.New("free space cannot be determined on this platform")
+
+type download struct {
+	obj       api.BCDNObject
+	remote    string
+	localPath string
+	modTime   time.Time
+}
+
+// pull mirrors syncPath of the zone into localPath: objects missing or
+// different locally are downloaded and, with Delete, local files the zone
+// does not have are removed. It uses the same comparison as a push, with
+// the roles of the two sides swapped.
+func (s *BCDNSyncer) pull(ctx context.Context, localPath, syncPath string) error {
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", localPath, err)
+	}
+
+	s.logger().Infof("Fetching remote objects (parallel scan)...")
+	objMap, err := s.fetchAllObjectsParallel(ctx, syncPath)
+	if err != nil {
+		return fmt.Errorf("failed to fetch remote objects: %w", err)
+	}
+	s.logger().Infof("Fetched %d remote objects", len(objMap))
+
+	var uploaded map[string]manifestEntry
+	if s.UseManifest {
+		uploaded = s.pullManifest(ctx, syncPath, objMap)
+	}
+
+	metrics := s.newMetrics()
+	remoteFiles := map[string]bool{}
+	var downloads []download
+	for _, obj := range objMap {
+		remote := objectPath(s.API.ZoneName, obj)
+		rel := remote
+		if syncPath != "" {
+			rel = strings.TrimPrefix(remote, syncPath+"/")
+		}
+		if s.isJunk(rel) || !s.selected(rel) || rel == ManifestName || remote == syncMarkerName {
+			continue
+		}
+		// An object name is only trusted as a path below localPath; one
+		// that would resolve outside it is never written.
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			err := fmt.Errorf("object name %q leaves the local directory", rel)
+			s.logger().Errorf("refusing to download %s: %v", remote, err)
+			metrics.fail("download", remote, err)
+			continue
+		}
+		remoteFiles[rel] = true
+		metrics.total.Add(1)
+		dst := filepath.Join(localPath, filepath.FromSlash(rel))
+
+		info, err := os.Stat(dst)
+		switch {
+		case os.IsNotExist(err):
+			metrics.newFile.Add(1)
+		case err != nil:
+			s.logger().Errorf("accessing %s: %v", dst, err)
+			metrics.fail("read", rel, err)
+			continue
+		case s.OnlyMissing:
+			metrics.skip(rel)
+			continue
+		case s.sizeOnly():
+			if info.Size() == int64(obj.Length) || storedCompressed(dst, obj, true) {
+				metrics.skip(rel)
+				continue
+			}
+			metrics.modifiedFile.Add(1)
+		default:
+			checksum, err := getFileChecksum(dst)
+			if err != nil {
+				s.logger().Errorf("reading file %s: %v", dst, err)
+				metrics.fail("read", rel, err)
+				continue
+			}
+			if strings.EqualFold(checksum, obj.Checksum) || storedCompressed(dst, obj, false) {
+				metrics.skip(rel)
+				continue
+			}
+			metrics.modifiedFile.Add(1)
+		}
+		d := download{obj: obj, remote: remote, localPath: dst}
+		if e, ok := uploaded[remote]; ok && e.matches(obj) {
+			d.modTime = e.ModTime
+		}
+		downloads = append(downloads, d)
+		metrics.plan("download", remote, int64(obj.Length), "")
+	}
+
+	if err := s.checkFreeSpace(localPath, downloads); err != nil {
+		s.printSummary(metrics)
+		return err
+	}
+	if s.Sorted {
+		sort.SliceStable(downloads, func(i, j int) bool { return shallowerFirst(downloads[i].remote, downloads[j].remote) })
+	}
+	if err := s.processDownloadsConcurrently(ctx, downloads, metrics); err != nil {
+		s.printSummary(metrics)
+		return err
+	}
+	if ctx.Err() != nil {
+		s.printSummary(metrics)
+		return fmt.Errorf("sync interrupted: %w", ctx.Err())
+	}
+
+	if s.Delete {
+		if err := s.deleteLocalOrphans(localPath, remoteFiles, metrics); err != nil {
+			s.printSummary(metrics)
+			return err
+		}
+	}
+
+	s.printSummary(metrics)
+	return nil
+}
+
+// checkFreeSpace fails the pull before any download when the planned
+// downloads do not fit on the filesystem holding localPath. Each file is
+// written next to its destination before replacing it, so the full size of
+// every download is counted, also for files that already exist.
+func (s *BCDNSyncer) checkFreeSpace(localPath string, downloads []download) error {
+	if s.DryRun || s.SkipSpaceCheck || len(downloads) == 0 {
+		return nil
+	}
+	var need int64
+	for _, d := range downloads {
+		need += int64(d.obj.Length)
+	}
+	free, err := freeSpace(localPath)
+	if errors.Is(err, errSpaceUnknown) {
+		s.logDebug("Skipping the free space check: %v", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking free space in %s: %w (--skip-space-check skips the check)", localPath, err)
+	}
+	if need > free {
+		return fmt.Errorf("not enough free space in %s: %s to download, %s available (--skip-space-check skips the check)", localPath, formatBytes(need), formatBytes(free))
+	}
+	return nil
+}
+
+// pullManifest reads the zone manifest listed in objMap, if any, so
+// downloads get back the mtimes their files had when they were uploaded.
+func (s *BCDNSyncer) pullManifest(ctx context.Context, syncPath string, objMap map[string]api.BCDNObject) map[string]manifestEntry {
+	for _, obj := range objMap {
+		if objectPath(s.API.ZoneName, obj) != manifestPath(syncPath) {
+			continue
+		}
+		m, err := s.readManifest(ctx, syncPath, obj.Checksum)
+		if err != nil {
+			s.warnf("zone manifest unusable, using storage modification times: %v", err)
+			return nil
+		}
+		return m.entries()
+	}
+	return nil
+}
+
+func (s *BCDNSyncer) processDownloadsConcurrently(ctx context.Context, downloads []download, metrics *syncMetrics) error {
+	ctx, stop := newFatalStop(ctx)
+	defer stop.cancel()
+	sem := make(chan struct{}, s.workers(s.DownloadConcurrency))
+	var wg sync.WaitGroup
+	for _, d := range downloads {
+		wg.Add(1)
+		if s.Sorted {
+			sem <- struct{}{}
+		}
+		go func(d download) {
+			defer wg.Done()
+			if !s.Sorted {
+				sem <- struct{}{}
+			}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			s.fileStart(metrics, d.remote, int64(d.obj.Length))
+			if s.DryRun {
+				s.logger().Infof("DRY-RUN: Would download %s", d.remote)
+				s.fileComplete(metrics, d.remote, nil)
+				metrics.done("download", d.remote)
+				return
+			}
+			if err := s.breaker.acquire(ctx); err != nil {
+				s.fileComplete(metrics, d.remote, err)
+				return
+			}
+			ctx, done := s.inFlight(ctx)
+			defer done()
+			err := s.downloadFile(ctx, d)
+			s.breaker.finish(ctx, err)
+			s.fileComplete(metrics, d.remote, err)
+			if err != nil {
+				stop.check(err)
+				s.logger().Errorf("download failed for %s: %v", d.remote, err)
+				metrics.fail("download", d.remote, err)
+				return
+			}
+			metrics.bytesDownloaded.Add(int64(d.obj.Length))
+			metrics.done("download", d.remote)
+		}(d)
+	}
+	wg.Wait()
+	return stop.err
+}
+
+// downloadFile streams the object into a file next to its destination and
+// renames it into place, so an interrupted download never leaves a truncated
+// file behind and memory use does not grow with the file size.
+func (s *BCDNSyncer) downloadFile(ctx context.Context, d download) error {
+	if err := os.MkdirAll(filepath.Dir(d.localPath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.localPath), filepath.Base(d.localPath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := s.API.DownloadStreamContext(ctx, d.remote, io.MultiWriter(tmp, h)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if d.obj.Checksum != "" && !strings.EqualFold(d.obj.Checksum, fmt.Sprintf("%x", h.Sum(nil))) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("downloaded content does not match the listed checksum")
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// Objects pushed with Compress hold gzip data; the local copy gets the
+	// original file back.
+	if err := gunzipDownload(tmp.Name(), d.localPath); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), d.localPath); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	modTime := d.modTime
+	if modTime.IsZero() {
+		modTime = d.obj.LastChanged.Time
+	}
+	if !modTime.IsZero() {
+		os.Chtimes(d.localPath, modTime, modTime)
+	}
+	s.logger().Infof("Downloaded %s", d.remote)
+	return nil
+}
+
+func (s *BCDNSyncer) deleteLocalOrphans(localPath string, remoteFiles map[string]bool, metrics *syncMetrics) error {
+	var deleteOps []string
+	sizes := map[string]int64{}
+	localCount := 0
+	err := filepath.Walk(localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(localPath, p)
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if p != localPath && (s.skipName(info.Name()) || s.excludedDir(rel)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if s.isJunk(rel) || !s.selected(rel) {
+			return nil
+		}
+		localCount++
+		if !remoteFiles[rel] {
+			deleteOps = append(deleteOps, rel)
+			sizes[rel] = info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("filesystem walk failed: %w", err)
+	}
+	if len(deleteOps) == 0 {
+		return nil
+	}
+	sort.Strings(deleteOps)
+
+	// The zone is the source here, so the empty-source guard and the delete
+	// ceiling are measured against the local files.
+	deleteOps, err = s.approveDeletes(deleteOps, localCount, len(remoteFiles), "local")
+	if err != nil || len(deleteOps) == 0 {
+		return err
+	}
+
+	metrics.deletedFile.Store(int64(len(deleteOps)))
+	for _, rel := range deleteOps {
+		metrics.plan("delete-local", rel, sizes[rel], "not in zone")
+		s.fileStart(metrics, rel, 0)
+		if s.DryRun {
+			s.logger().Infof("DRY-RUN: Would delete local %s", rel)
+			s.fileComplete(metrics, rel, nil)
+			metrics.done("delete", rel)
+			continue
+		}
+		s.logger().Infof("Deleting local %s", rel)
+		err := os.Remove(filepath.Join(localPath, filepath.FromSlash(rel)))
+		if err != nil {
+			s.logger().Errorf("delete failed for %s: %v", rel, err)
+			metrics.fail("delete", rel, err)
+		} else {
+			metrics.bytesDeleted.Add(sizes[rel])
+			metrics.done("delete", rel)
+		}
+		s.fileComplete(metrics, rel, err)
+	}
+	return nil
+}
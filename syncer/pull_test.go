@@ -0,0 +1,98 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+func TestPullRejectsNamesOutsideTarget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			fmt.Fprint(w, `[
+				{"Path": "/zone/", "ObjectName": "../evil.txt", "Length": 1, "LastChanged": "2024-01-01T00:00:00"},
+				{"Path": "/zone/", "ObjectName": "ok.txt", "Length": 1, "LastChanged": "2024-01-01T00:00:00"}
+			]`)
+			return
+		}
+		w.Write([]byte("x"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	s := BCDNSyncer{
+		API:       api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+		Direction: DirectionPull,
+		Logger:    discardLogger{},
+	}
+	res, err := s.Run(t.Context(), []string{filepath.Join(dir, "out")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+		t.Fatal("object name with .. was written outside the target directory")
+	}
+	if len(res.Downloaded) != 1 || res.Downloaded[0] != "ok.txt" {
+		t.Errorf("downloaded %v, want [ok.txt]", res.Downloaded)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Path != "../evil.txt" {
+		t.Errorf("errors %v, want one for ../evil.txt", res.Errors)
+	}
+}
+
+func TestPullDecompressesCompressedObjects(t *testing.T) {
+	page := []byte(strings.Repeat("<p>compressible</p>\n", 100))
+	stored, err := gzipBytes(page)
+	if err != nil || stored == nil {
+		t.Fatalf("gzipBytes: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			fmt.Fprintf(w, `[{"Path": "/zone/", "ObjectName": "index.html", "Length": %d, "Checksum": "%X", "LastChanged": "2024-01-01T00:00:00"}]`,
+				len(stored), sha256.Sum256(stored))
+			return
+		}
+		w.Write(stored)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	// The first pull downloads; the later ones compare the decompressed
+	// file against the compressed object by checksum and by size.
+	for i, algo := range []string{ChecksumSHA256, ChecksumSHA256, ChecksumNone} {
+		s := BCDNSyncer{
+			API:          api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+			Direction:    DirectionPull,
+			ChecksumAlgo: algo,
+			Logger:       discardLogger{},
+		}
+		res, err := s.Run(t.Context(), []string{dir}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(page) {
+			t.Fatalf("pulled file holds %d bytes that are not the original page", len(got))
+		}
+		if i > 0 && len(res.Downloaded) != 0 {
+			t.Errorf("pull %d (%s) downloaded %v, want nothing", i+1, algo, res.Downloaded)
+		}
+	}
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debugf(string, ...interface{}) {}
+func (discardLogger) Infof(string, ...interface{})  {}
+func (discardLogger) Warnf(string, ...interface{})  {}
+func (discardLogger) Errorf(string, ...interface{}) {}
@@ -0,0 +1,79 @@
+package syncer
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// purgeURLs maps changed zone paths to the public URLs the pull zone serves
+// them under. An index.html is also cached under its directory URL.
+func (s *BCDNSyncer) purgeURLs(changed []string) []string {
+	base := s.PullZoneHostname
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	base = strings.TrimRight(base, "/")
+
+	seen := map[string]bool{}
+	var urls []string
+	add := func(p string) {
+		segments := strings.Split(p, "/")
+		for i, seg := range segments {
+			segments[i] = url.PathEscape(seg)
+		}
+		u := base + "/" + strings.Join(segments, "/")
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, p := range changed {
+		add(p)
+		if name := path.Base(p); name == "index.html" || name == "index.htm" {
+			add(strings.TrimSuffix(p, name))
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func (s *BCDNSyncer) purgeChanged(ctx context.Context, metrics *syncMetrics) {
+	metrics.Lock()
+	changed := append([]string(nil), metrics.changed...)
+	metrics.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+
+	urls := s.purgeURLs(changed)
+	if s.DryRun {
+		for _, u := range urls {
+			s.logger().Infof("DRY-RUN: Would purge %s", u)
+		}
+		return
+	}
+	s.logger().Infof("Purging %d URLs from the CDN cache", len(urls))
+
+	sem := make(chan struct{}, s.Concurrency)
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+			if err := s.API.PurgeContext(ctx, u); err != nil {
+				s.logger().Errorf("%v", err)
+				metrics.fail("purge", u, err)
+			}
+		}(u)
+	}
+	wg.Wait()
+}
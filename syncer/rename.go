@@ -0,0 +1,56 @@
+package syncer
+
+import (
+	"strings"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+// detectRenames pairs new uploads with remote files about to be deleted that
+// hold the same content, and labels both sides in the plan. Bunny Storage
+// has no server-side copy or move, so a rename is still uploaded again; the
+// checksum computed for the match is kept for the upload's Checksum header.
+func (s *BCDNSyncer) detectRenames(operations []operation, objMap map[string]api.BCDNObject) {
+	if s.ChecksumAlgo == ChecksumNone {
+		return
+	}
+	bySize := map[int64][]string{}
+	byChecksum := map[string][]string{}
+	for key, obj := range objMap {
+		p := objectPath(s.API.ZoneName, obj)
+		if obj.IsDirectory || obj.Checksum == "" || s.isJunk(p) {
+			continue
+		}
+		bySize[int64(obj.Length)] = append(bySize[int64(obj.Length)], key)
+		checksum := strings.ToUpper(obj.Checksum)
+		byChecksum[checksum] = append(byChecksum[checksum], key)
+	}
+	if len(byChecksum) == 0 {
+		return
+	}
+
+	s.renames = map[string]string{}
+	for i := range operations {
+		o := &operations[i]
+		// The zone stores compressed files under their compressed size, so
+		// only uncompressed uploads can be ruled out by size.
+		if !o.isNew || (!s.compressible(o.path) && len(bySize[o.size]) == 0) {
+			continue
+		}
+		checksum, err := s.fileChecksum(o.path, o.size, o.modTime)
+		if err != nil {
+			continue
+		}
+		o.checksum = checksum
+		for _, key := range byChecksum[strings.ToUpper(checksum)] {
+			old := objectPath(s.API.ZoneName, objMap[key])
+			if _, taken := s.renames[old]; taken {
+				continue
+			}
+			s.renames[old] = o.relPath
+			o.reason = "renamed from " + old
+			s.logger().Infof("Detected rename %s -> %s; the zone has no server-side move, so it is uploaded again", old, o.relPath)
+			break
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package syncer
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Reason codes of uploads and updates.
+const (
+	CodeNew     = "new"
+	CodeChanged = "changed"
+	// CodeDrift marks a file that changed in the zone but not locally since
+	// it was last uploaded; see DetectDrift.
+	CodeDrift = "drift"
+)
+
+type ReportOperation struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// PlanTotal sums the planned operations of one action.
+type PlanTotal struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+type report struct {
+	DryRun        bool                 `json:"dryRun"`
+	Operations    []ReportOperation    `json:"operations"`
+	Totals        map[string]PlanTotal `json:"totals"`
+	TransferBytes int64                `json:"transferBytes"`
+	Conflicts     []Conflict           `json:"conflicts,omitempty"`
+	Metrics       *syncMetrics         `json:"metrics"`
+}
+
+// planSections lists the planned actions in report order with their titles
+// and the marker printed before each path.
+var planSections = []struct {
+	action, title, marker string
+}{
+	{"upload", "New uploads", "+"},
+	{"update", "Updates", "~"},
+	{"download", "Downloads", "+"},
+	{"delete", "Deletes", "-"},
+	{"delete-local", "Local deletes", "-"},
+}
+
+func (o operation) uploadAction() string {
+	if o.isNew {
+		return "upload"
+	}
+	return "update"
+}
+
+func (o operation) report() ReportOperation {
+	code := CodeChanged
+	switch {
+	case o.drift:
+		code = CodeDrift
+	case o.isNew:
+		code = CodeNew
+	}
+	return ReportOperation{Action: o.uploadAction(), Path: o.relPath, Size: o.size, Code: code, Reason: o.reason}
+}
+
+func (m *syncMetrics) plan(action, path string, size int64, reason string) {
+	m.planOp(ReportOperation{Action: action, Path: path, Size: size, Reason: reason})
+}
+
+func (m *syncMetrics) planOp(op ReportOperation) {
+	m.Lock()
+	defer m.Unlock()
+	m.planned = append(m.planned, op)
+	if op.Action == "delete" {
+		if m.deleteSizes == nil {
+			m.deleteSizes = map[string]int64{}
+		}
+		m.deleteSizes[op.Path] = op.Size
+	}
+}
+
+// planTotals groups ops by action and sums the bytes that would be sent or
+// received; deletes count towards their own group only.
+func planTotals(ops []ReportOperation) (map[string]PlanTotal, int64) {
+	totals := map[string]PlanTotal{}
+	var transfer int64
+	for _, o := range ops {
+		t := totals[o.Action]
+		t.Count++
+		t.Bytes += o.Size
+		totals[o.Action] = t
+		switch o.Action {
+		case "upload", "update", "download":
+			transfer += o.Size
+		}
+	}
+	return totals, transfer
+}
+
+// printPlan logs the planned operations grouped by action, each group with
+// its file count and size, followed by the bytes to transfer.
+func (s *BCDNSyncer) printPlan(m *syncMetrics) {
+	m.Lock()
+	ops := append([]ReportOperation{}, m.planned...)
+	m.Unlock()
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Path < ops[j].Path })
+	totals, transfer := planTotals(ops)
+	log := s.summaryLogger()
+
+	log.Infof("=== Dry-Run Plan ===")
+	for _, section := range planSections {
+		t, ok := totals[section.action]
+		if !ok {
+			continue
+		}
+		log.Infof("%s: %d %s, %s", section.title, t.Count, plural(t.Count, "file", "files"), formatBytes(t.Bytes))
+		for _, o := range ops {
+			if o.Action == section.action {
+				log.Infof("  %s %s (%s)%s", section.marker, o.Path, formatBytes(o.Size), codeLabel(o))
+			}
+		}
+	}
+	if len(ops) == 0 {
+		log.Infof("Nothing to do")
+	}
+	if drift := countCode(ops, CodeDrift); drift > 0 {
+		log.Infof("Remote drift: %d %s changed in the zone but not locally", drift, plural(drift, "file", "files"))
+	}
+	log.Infof("Total to transfer: %s", formatBytes(transfer))
+}
+
+// codeLabel is the " [code: reason]" suffix of a plan line, or "" for
+// operations without a code.
+func codeLabel(o ReportOperation) string {
+	switch {
+	case o.Code == "":
+		return ""
+	case o.Reason == "" || o.Reason == o.Code:
+		return " [" + o.Code + "]"
+	}
+	return " [" + o.Code + ": " + o.Reason + "]"
+}
+
+func countCode(ops []ReportOperation, code string) int {
+	n := 0
+	for _, o := range ops {
+		if o.Code == code {
+			n++
+		}
+	}
+	return n
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+func (m *syncMetrics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.summary())
+}
+
+func (s *BCDNSyncer) writeReport(m *syncMetrics) {
+	m.Lock()
+	ops := append([]ReportOperation{}, m.planned...)
+	m.Unlock()
+
+	enc := json.NewEncoder(s.JSONOutput)
+	enc.SetIndent("", "  ")
+	totals, transfer := planTotals(ops)
+	if err := enc.Encode(report{DryRun: s.DryRun, Operations: ops, Totals: totals, TransferBytes: transfer, Conflicts: m.conflictList(), Metrics: m}); err != nil {
+		s.logger().Errorf("writing JSON report: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SyncResult describes what a run did. In a dry run the path lists hold the
+// operations that would have been performed. Paths are zone paths, except
+// for Downloaded and local deletes in pull mode, which are relative to the
+// local directory.
+type SyncResult struct {
+	Uploaded   []string
+	Updated    []string
+	Downloaded []string
+	Deleted    []string
+	Skipped    []string
+	Errors     []FileError
+	Conflicts  []Conflict
+	Summary    Summary
+}
+
+// FileError is a failure that affected a single file and did not stop the
+// run. Op is one of walk, probe, read, upload, delete, download, generate,
+// verify, manifest, marker or purge.
+type FileError struct {
+	Path string
+	Op   string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e FileError) Unwrap() error { return e.Err }
+
+// Run is SyncSourcesContext returning a SyncResult. The result is filled in
+// as far as the run got, also when an error stopped it early.
+func (s *BCDNSyncer) Run(ctx context.Context, sources []string, syncPath string) (*SyncResult, error) {
+	s.metrics = nil
+	err := s.SyncSourcesContext(ctx, sources, syncPath)
+	if s.metrics == nil {
+		return &SyncResult{}, err
+	}
+	return s.metrics.result(), err
+}
+
+// newMetrics starts the counters of a run and remembers them for Run.
+func (s *BCDNSyncer) newMetrics() *syncMetrics {
+	s.metrics = &syncMetrics{started: s.started, abort: s.abort, maxErrors: int64(s.errorLimit())}
+	if s.metrics.started.IsZero() {
+		s.metrics.started = time.Now()
+	}
+	return s.metrics
+}
+
+func (m *syncMetrics) fail(op, path string, err error) {
+	m.Lock()
+	defer m.Unlock()
+	failed := m.errors.Add(1)
+	m.fileErrors = append(m.fileErrors, FileError{Path: path, Op: op, Err: err})
+	if m.abort != nil && failed >= m.maxErrors {
+		m.abort(&failFastError{last: FileError{Path: path, Op: op, Err: err}, count: failed})
+	}
+}
+
+func (m *syncMetrics) skip(path string) {
+	m.Lock()
+	defer m.Unlock()
+	m.skipped.Add(1)
+	m.skippedPaths = append(m.skippedPaths, path)
+}
+
+// done records a completed (or, in a dry run, planned) operation.
+func (m *syncMetrics) done(action, path string) {
+	m.Lock()
+	defer m.Unlock()
+	switch action {
+	case "upload":
+		m.uploadedPaths = append(m.uploadedPaths, path)
+	case "update":
+		m.updatedPaths = append(m.updatedPaths, path)
+	case "download":
+		m.downloadedPaths = append(m.downloadedPaths, path)
+	case "delete":
+		m.deletedPaths = append(m.deletedPaths, path)
+	}
+}
+
+func (m *syncMetrics) result() *SyncResult {
+	summary := m.summary()
+	m.Lock()
+	defer m.Unlock()
+	return &SyncResult{
+		Uploaded:   append([]string(nil), m.uploadedPaths...),
+		Updated:    append([]string(nil), m.updatedPaths...),
+		Downloaded: append([]string(nil), m.downloadedPaths...),
+		Deleted:    append([]string(nil), m.deletedPaths...),
+		Skipped:    append([]string(nil), m.skippedPaths...),
+		Errors:     append([]FileError(nil), m.fileErrors...),
+		Conflicts:  append([]Conflict(nil), m.conflicts...),
+		Summary:    summary,
+	}
+}
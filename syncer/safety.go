@@ -0,0 +1,34 @@
+package syncer
+
+import "fmt"
+
+func (s *BCDNSyncer) checkDeleteSafety(deleteOps []string, remoteCount, localCount int) error {
+	if s.RefuseEmptySource && localCount == 0 {
+		return fmt.Errorf("refusing to delete %d remote files: source contains no files", len(deleteOps))
+	}
+	if s.MaxDeletePercent > 0 && remoteCount > 0 {
+		percent := float64(len(deleteOps)) * 100 / float64(remoteCount)
+		if percent > s.MaxDeletePercent {
+			return fmt.Errorf("refusing to delete %d of %d remote files (%.1f%%): exceeds --max-delete-percent %.1f",
+				len(deleteOps), remoteCount, percent, s.MaxDeletePercent)
+		}
+	}
+	return nil
+}
+
+// approveDeletes runs the delete guards and, unless DryRun, asks
+// ConfirmDeletes. It returns the deletes to run, nil when the prompt was
+// declined; side names the files kept in that case, "remote" or "local".
+func (s *BCDNSyncer) approveDeletes(deleteOps []string, remoteCount, localCount int, side string) ([]string, error) {
+	if len(deleteOps) == 0 {
+		return deleteOps, nil
+	}
+	if err := s.checkDeleteSafety(deleteOps, remoteCount, localCount); err != nil {
+		return nil, err
+	}
+	if s.ConfirmDeletes != nil && !s.DryRun && !s.ConfirmDeletes(deleteOps) {
+		s.logger().Infof("Delete phase cancelled, %d %s files kept", len(deleteOps), side)
+		return nil, nil
+	}
+	return deleteOps, nil
+}
@@ -1,13 +1,17 @@
 package syncer
 
 import (
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/veter2005/bunny-storage-sync/api"
@@ -21,6 +25,189 @@ type BCDNSyncer struct {
 	Delete      bool
 	Concurrency int
 	Verbose     bool
+
+	// ProbeMissing, with OnlyMissing, looks up each local file in the zone
+	// instead of listing it, which is cheaper when the zone holds far more
+	// files than the source.
+	ProbeMissing bool
+
+	// Per-phase worker counts; zero uses Concurrency.
+	ListConcurrency     int
+	UploadConcurrency   int
+	DownloadConcurrency int
+	DeleteConcurrency   int
+
+	GenerateIndex       bool
+	AllowedContentTypes []string
+	DeleteCheckpoint    string
+	MaxObjects          int
+	MaxFileSize         int64
+
+	// Since skips files last modified before it without reading them.
+	Since time.Time
+
+	RefuseEmptySource bool
+	MaxDeletePercent  float64
+
+	// Sorted starts uploads, downloads and deletes in a fixed order, by path
+	// depth and then name, instead of as they were found, and has each
+	// worker take its slot before its goroutine starts. Operations still
+	// overlap unless the concurrency is 1.
+	Sorted bool
+
+	// DeleteAfterVerify skips the delete phase when any file failed, or was
+	// reported missing or different by VerifyViaRelist, before it.
+	DeleteAfterVerify bool
+	ConfirmDeletes    func(paths []string) bool
+
+	ProgressInterval time.Duration
+
+	// FailFast cancels the rest of a run on the first failed file instead of
+	// continuing with the others.
+	FailFast bool
+
+	// MaxErrors cancels the rest of a run, like FailFast, once this many
+	// files have failed; zero means no limit.
+	MaxErrors int
+
+	// Deadline caps the duration of a whole sync, or of Plan and Apply each;
+	// once it passes, no new operations start and requests in flight are
+	// aborted.
+	Deadline time.Duration
+
+	// Interrupt, once closed, stops the run like a cancelled context, except
+	// that uploads, downloads and deletes already in flight are left to
+	// finish. The run then returns ErrInterrupted.
+	Interrupt <-chan struct{}
+
+	Checkpoint         string
+	CheckpointInterval time.Duration
+
+	Keys KeyStrategy
+
+	NoDefaultExcludes bool
+	IncludeHidden     bool
+	FollowSymlinks    bool
+	Compress          bool
+	GitDiff           string
+
+	Include []string
+	Exclude []string
+
+	// Extensions, if set, limits the sync to files ending in one of them
+	// (e.g. "html" or ".css"); other files are neither uploaded nor deleted.
+	Extensions []string
+
+	// Private lists patterns, like Include, of files that must not become
+	// publicly readable. Bunny Storage cannot restrict single objects, so
+	// matching files are not uploaded and each fails with
+	// api.ErrNoAccessControl; existing copies in the zone are left alone.
+	Private []string
+
+	ChecksumCache string
+
+	// ChecksumAlgo selects how files are compared with the zone. The default
+	// ChecksumSHA256 is what Bunny lists and checks uploads against;
+	// ChecksumNone compares sizes like SizeOnly and uploads without a
+	// checksum, for zones whose listed checksums never match.
+	ChecksumAlgo string
+
+	Purge            bool
+	PullZoneHostname string
+
+	// JSONOutput receives a JSON report of planned operations and metrics
+	// in place of the summary log lines.
+	JSONOutput io.Writer
+	Progress   Progress
+
+	// Logger receives all log output; nil falls back to API.Logger and then
+	// to the standard library logger.
+	Logger api.Logger
+
+	CircuitBreaker   bool
+	CircuitThreshold float64
+	CircuitCooldown  time.Duration
+
+	PathMap     map[string]string
+	OnDuplicate string
+
+	// StripPrefix is removed from the front of every local path before it
+	// becomes a remote path, so with "dist/" the files below dist land at
+	// the sync path itself. Local files outside it are skipped unless
+	// PathMap maps them.
+	StripPrefix string
+
+	SanitizeNames   string
+	SanitizeMapFile string
+
+	VerifyViaRelist bool
+	VerifyUploads   bool
+	TransferBudget  int64
+
+	// Atomic stages every upload before any live path changes; see syncAtomic.
+	Atomic bool
+
+	GenerateSitemap bool
+	BaseURL         string
+
+	WriteSyncMarker bool
+	Version         string
+
+	PruneOnly      bool
+	PruneEmptyDirs bool
+	Direction      string
+
+	// SkipSpaceCheck lets a pull start even when the planned downloads do
+	// not fit in the free space of the local filesystem.
+	SkipSpaceCheck bool
+
+	UseManifest bool
+	FullList    bool
+
+	// DetectDrift makes a dry run list the whole zone and compare every
+	// file by checksum, and marks updates of files that changed only in the
+	// zone, judged against the zone manifest, as drift.
+	DetectDrift bool
+
+	// TrustCache skips reading local files whose size and mtime match the
+	// zone manifest's record of their last upload, as long as the listed
+	// object still has the checksum of that upload.
+	TrustCache bool
+
+	// NoOverwriteNewer skips updating files whose zone copy changed after
+	// the local file was last modified, recording them as conflicts instead,
+	// so edits made in the zone are not lost. See remoteNewer.
+	NoOverwriteNewer bool
+
+	breaker   *circuitBreaker
+	renamed   map[string]string
+	prefix    string
+	sourceFS  fs.FS
+	manifest  *manifestState
+	uploaded  map[string]manifestEntry
+	ignore    ignoreSet
+	hashCache *checksumCache
+	metrics   *syncMetrics
+	started   time.Time
+	abort     context.CancelCauseFunc
+	lastPlan  *SyncPlan
+
+	// uninterrupted is the run's context without Interrupt; see inFlight.
+	uninterrupted context.Context
+
+	// remoteDirs and localDirs let deleteOrphans collapse whole removed
+	// directories into one delete; see collapseDeletes. remoteDirs stays nil
+	// when the remote objects came from the zone manifest, which does not
+	// know about files written by other tools.
+	remoteDirs   map[string]int
+	localDirs    map[string]bool
+	listedDirs   []string
+	removedDirs  map[string]bool
+	fromManifest bool
+
+	// renames maps delete candidates to the new uploads with the same
+	// content; see detectRenames.
+	renames map[string]string
 }
 
 type operation struct {
@@ -28,300 +215,1044 @@ type operation struct {
 	path     string
 	relPath  string
 	checksum string
+	size     int64
+	modTime  time.Time
 	isNew    bool
+	drift    bool
+	reason   string
+}
+
+type localFile struct {
+	relPath string
+	size    int64
+	modTime time.Time
 }
 
+// syncMetrics counts a run's files and bytes. The counters are updated
+// without the mutex, so workers do not serialize on them; the mutex guards
+// the lists, and a counter that moves together with a list, such as skipped
+// with skippedPaths, is changed while it is held.
 type syncMetrics struct {
 	sync.Mutex
-	total        int
-	newFile      int
-	modifiedFile int
-	deletedFile  int
-	skipped      int
-	errors       int
+	total        atomic.Int64
+	newFile      atomic.Int64
+	modifiedFile atomic.Int64
+	deletedFile  atomic.Int64
+	skipped      atomic.Int64
+	errors       atomic.Int64
+	verifyFailed atomic.Int64
+	deferred     atomic.Int64
+	transferred  atomic.Int64
+	changed      []string
+
+	bytesUploaded   atomic.Int64
+	bytesDownloaded atomic.Int64
+	bytesDeleted    atomic.Int64
+	deleteSizes     map[string]int64
+	started         time.Time
+	deletesHeld     bool
+	conflicts       []Conflict
+
+	planned []ReportOperation
+
+	uploadedPaths   []string
+	updatedPaths    []string
+	downloadedPaths []string
+	deletedPaths    []string
+	skippedPaths    []string
+	fileErrors      []FileError
+
+	// abort cancels the run once maxErrors files failed, with FailFast or
+	// MaxErrors.
+	abort     context.CancelCauseFunc
+	maxErrors int64
 }
 
 func (s *BCDNSyncer) Sync(sourcePath string, syncPath string) error {
-	if _, err := os.Stat(sourcePath); err != nil {
-		return fmt.Errorf("source path error: %w", err)
+	return s.SyncContext(context.Background(), sourcePath, syncPath)
+}
+
+// SyncContext is Sync with cancellation: once ctx is done no new operations
+// start, requests in flight are aborted and the delete phase is skipped.
+func (s *BCDNSyncer) SyncContext(ctx context.Context, sourcePath string, syncPath string) error {
+	return s.SyncSourcesContext(ctx, []string{sourcePath}, syncPath)
+}
+
+// SyncSources merges several local directories into one zone path, as if
+// their contents had been copied into a single tree. Files present in any
+// source are kept remotely; a relative path found in more than one source is
+// handled according to OnDuplicate.
+func (s *BCDNSyncer) SyncSources(sources []string, syncPath string) error {
+	return s.SyncSourcesContext(context.Background(), sources, syncPath)
+}
+
+func (s *BCDNSyncer) SyncSourcesContext(ctx context.Context, sources []string, syncPath string) error {
+	s.started = time.Now()
+	return s.withLimits(ctx, func(ctx context.Context) error {
+		return s.syncSources(ctx, sources, syncPath)
+	})
+}
+
+func (s *BCDNSyncer) syncSources(ctx context.Context, sources []string, syncPath string) error {
+	syncPath, err := s.prepare(sources, syncPath)
+	if err != nil {
+		return err
+	}
+	if err := s.API.CheckAccessContext(ctx); err != nil {
+		return err
+	}
+	sourcePath := sources[0]
+
+	if s.Direction == DirectionPull {
+		return s.pull(ctx, sourcePath, syncPath)
+	}
+	if s.GitDiff != "" {
+		return s.syncGitDiff(ctx, sourcePath, syncPath)
+	}
+
+	var resumed *syncMetrics
+	if s.Delete && s.DeleteCheckpoint != "" && !s.DryRun {
+		if resumed, err = s.resumeDeletes(ctx, sources, syncPath); err != nil {
+			return err
+		}
 	}
 
+	plan, candidates, err := s.scan(ctx, sources, syncPath)
+	if err != nil {
+		return err
+	}
+	if resumed != nil {
+		plan.metrics.addDeletes(resumed)
+	}
+	defer s.saveChecksumCache()
+
+	if s.PruneOnly {
+		return s.pruneOnly(ctx, sources, syncPath, candidates, plan.objMap, plan.remoteCount, plan.metrics)
+	}
+	if err := s.classify(ctx, plan, candidates); err != nil {
+		return err
+	}
+	return s.apply(ctx, plan)
+}
+
+// prepare checks the settings of a run and resets the state left by the
+// previous one. It returns syncPath without surrounding slashes.
+func (s *BCDNSyncer) prepare(sources []string, syncPath string) (string, error) {
+	if len(sources) == 0 {
+		return "", fmt.Errorf("no source path given")
+	}
+	for _, sourcePath := range sources {
+		if _, err := s.statLocal(sourcePath); err != nil && s.Direction != DirectionPull {
+			return "", fmt.Errorf("source path error: %w", err)
+		}
+	}
 	if s.Concurrency <= 0 {
 		s.Concurrency = 5
 	}
 
-	syncPath = strings.Trim(syncPath, "/")
+	syncPath, err := cleanSyncPath(syncPath)
+	if err != nil {
+		return "", err
+	}
+	if syncPath == "" && (s.Delete || s.PruneOnly) && s.Direction != DirectionPull {
+		s.warnf("deleting with an empty sync path mirrors the whole zone root; every object in the zone without a local source will be removed")
+	}
+
+	s.manifest, s.uploaded = nil, nil
+	s.remoteDirs, s.localDirs, s.listedDirs, s.fromManifest = nil, nil, nil, false
+	s.removedDirs = map[string]bool{}
+	s.renames = nil
+	s.ignore = newIgnoreSet(sources, s.logger())
+	if s.sourceFS != nil {
+		s.ignore = ignoreSet{newIgnoreRules(s.sourceFS, s.logger())}
+	}
+	s.breaker = nil
+	if s.CircuitBreaker {
+		s.breaker = newCircuitBreaker(s.CircuitThreshold, s.CircuitCooldown, s.logger())
+	}
+
+	if s.GenerateSitemap && s.BaseURL == "" {
+		return "", fmt.Errorf("sitemap generation requires a base URL")
+	}
+	if s.Purge && s.PullZoneHostname == "" {
+		return "", fmt.Errorf("purging requires a pull zone hostname")
+	}
+	if s.Atomic && (s.TransferBudget > 0 || s.GitDiff != "" || s.Direction == DirectionPull) {
+		return "", fmt.Errorf("atomic mode cannot be combined with a transfer budget, a git diff or pull mode")
+	}
+
+	switch s.SanitizeNames {
+	case "", SanitizeWarn, SanitizeError, SanitizeSkip, SanitizeRewrite:
+	default:
+		return "", fmt.Errorf("invalid name sanitizing policy %q", s.SanitizeNames)
+	}
+
+	switch s.OnDuplicate {
+	case "", DuplicateError, DuplicateLastWins:
+	default:
+		return "", fmt.Errorf("invalid duplicate policy %q (want %q or %q)", s.OnDuplicate, DuplicateError, DuplicateLastWins)
+	}
+
+	if s.prefix, err = cleanStripPrefix(s.StripPrefix); err != nil {
+		return "", err
+	}
+	if s.prefix != "" && s.Direction == DirectionPull {
+		return "", fmt.Errorf("a strip prefix cannot be used in pull mode")
+	}
+	if err := s.validatePathMap(sources); err != nil {
+		return "", err
+	}
+
+	switch s.Direction {
+	case "", DirectionPush:
+	case DirectionPull:
+		if s.GitDiff != "" || s.PruneOnly {
+			return "", fmt.Errorf("pull mode cannot be combined with a git diff or prune-only")
+		}
+		if len(sources) > 1 {
+			return "", fmt.Errorf("pull mode downloads into a single directory")
+		}
+	default:
+		return "", fmt.Errorf("invalid direction %q (want %q or %q)", s.Direction, DirectionPush, DirectionPull)
+	}
+
+	if s.DetectDrift && (!s.DryRun || s.SizeOnly || s.OnlyMissing || !s.Since.IsZero() || s.GitDiff != "" || s.PruneOnly || s.Direction == DirectionPull) {
+		return "", fmt.Errorf("drift detection needs a checksum-comparing push dry run")
+	}
+	switch s.ChecksumAlgo {
+	case "", ChecksumSHA256:
+	case ChecksumNone:
+		if s.DetectDrift || s.TrustCache || s.VerifyUploads || s.VerifyViaRelist || s.Atomic {
+			return "", fmt.Errorf("checksum algorithm %q cannot be combined with drift detection, trusted checksums, verification or atomic mode", s.ChecksumAlgo)
+		}
+	default:
+		return "", fmt.Errorf("invalid checksum algorithm %q (want %q or %q)", s.ChecksumAlgo, ChecksumSHA256, ChecksumNone)
+	}
+	if s.ProbeMissing && (!s.OnlyMissing || s.Delete || s.PruneOnly || s.PruneEmptyDirs || s.GitDiff != "" || s.UseManifest || s.DetectDrift || s.TrustCache || s.Direction == DirectionPull) {
+		return "", fmt.Errorf("probing for missing files needs --only-missing and a push without deletes, pruning, a git diff or the zone manifest")
+	}
+	if s.NoOverwriteNewer && s.Direction == DirectionPull {
+		return "", fmt.Errorf("refusing to overwrite newer remote files only applies to pushes")
+	}
+	if s.TrustCache && (s.DetectDrift || s.Direction == DirectionPull) {
+		return "", fmt.Errorf("trusting recorded checksums cannot be combined with drift detection or pull mode")
+	}
+	if s.PruneOnly && s.GitDiff != "" {
+		return "", fmt.Errorf("prune-only mode cannot be combined with a git diff")
+	}
+	if s.GitDiff != "" && len(sources) > 1 {
+		return "", fmt.Errorf("a git diff sync needs a single source directory")
+	}
+	return syncPath, nil
+}
+
+// cleanSyncPath turns syncPath into a slash-separated path below the zone
+// root. ".." segments and local absolute paths, such as a drive letter or a
+// home directory, are rejected; a leading slash only anchors at the zone root.
+func cleanSyncPath(syncPath string) (string, error) {
+	p := strings.ReplaceAll(syncPath, "\\", "/")
+	if strings.HasPrefix(p, "//") || strings.HasPrefix(p, "~") || (len(p) >= 2 && p[1] == ':') {
+		return "", fmt.Errorf("sync path %q looks like a local path; it names a directory in the zone", syncPath)
+	}
+	var segments []string
+	for _, seg := range strings.Split(p, "/") {
+		switch seg {
+		case "", ".":
+		case "..":
+			return "", fmt.Errorf("sync path %q must not contain .. segments", syncPath)
+		default:
+			segments = append(segments, seg)
+		}
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// scan lists the zone and walks the sources, returning a plan with nothing
+// decided yet and the local files to classify.
+func (s *BCDNSyncer) scan(ctx context.Context, sources []string, syncPath string) (*SyncPlan, []candidate, error) {
+	objMap := map[string]api.BCDNObject{}
+	var err error
+	if !s.ProbeMissing {
+		objMap, err = s.loadRemoteObjects(ctx, syncPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch remote objects: %w", err)
+		}
+		if s.WriteSyncMarker {
+			delete(objMap, syncMarkerName)
+		}
+		s.logger().Infof("Fetched %d remote objects", len(objMap))
+	}
+	remoteCount := len(objMap)
+	remoteFiles := make([]string, 0, len(objMap))
+	for _, obj := range objMap {
+		if !obj.IsDirectory {
+			remoteFiles = append(remoteFiles, objectPath(s.API.ZoneName, obj))
+		}
+	}
+	if !s.fromManifest {
+		s.remoteDirs = countFiles(syncPath, remoteFiles)
+	}
+
+	s.hashCache = nil
+	if s.ChecksumCache != "" {
+		s.hashCache, err = loadChecksumCache(s.ChecksumCache)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read checksum cache: %w", err)
+		}
+	}
+
+	var cp *checkpoint
+	if s.Checkpoint != "" && !s.DryRun {
+		cp, err = loadCheckpoint(s.Checkpoint)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+		if n := len(cp.Completed); n > 0 {
+			s.logger().Infof("Loaded checkpoint with %d completed uploads", n)
+		}
+	}
 
-	fmt.Println("Fetching remote objects (parallel scan)...")
-	objMap, err := s.fetchAllObjectsParallel(syncPath)
+	metrics := s.newMetrics()
+	candidates, err := s.collectLocalFiles(sources, syncPath, metrics)
 	if err != nil {
-		return fmt.Errorf("failed to fetch remote objects: %w", err)
+		return nil, nil, fmt.Errorf("filesystem walk failed: %w", err)
+	}
+	if s.ProbeMissing {
+		objMap = s.probeCandidates(ctx, candidates, metrics)
+		remoteCount = len(objMap)
+	}
+
+	plan := &SyncPlan{
+		sources:     sources,
+		syncPath:    syncPath,
+		objMap:      objMap,
+		remoteCount: remoteCount,
+		metrics:     metrics,
+		cp:          cp,
 	}
-	log.Printf("Fetched %d remote objects", len(objMap))
+	s.lastPlan = plan
+	return plan, candidates, nil
+}
 
-	metrics := &syncMetrics{}
+func (s *BCDNSyncer) saveChecksumCache() {
+	if err := s.hashCache.save(); err != nil {
+		s.logger().Errorf("writing checksum cache: %v", err)
+	}
+}
+
+// classify compares every candidate with the zone and records the uploads
+// and deletes the plan would apply.
+func (s *BCDNSyncer) classify(ctx context.Context, plan *SyncPlan, candidates []candidate) error {
+	objMap, metrics, cp := plan.objMap, plan.metrics, plan.cp
 	operations := []operation{}
+	localFiles := []localFile{}
 	var opsLock sync.Mutex
+	var err error
 
-	err = filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Printf("ERROR: accessing path %q: %v\n", path, err)
-			metrics.Lock()
-			metrics.errors++
-			metrics.Unlock()
-			return nil
+	if len(s.renamed) > 0 && s.SanitizeMapFile != "" && !s.DryRun {
+		if err := writeSanitizeMap(s.SanitizeMapFile, s.renamed); err != nil {
+			return fmt.Errorf("failed to write sanitize map: %w", err)
 		}
+	}
+
+	hashes := s.hashCandidates(ctx, candidates, func(c candidate) bool {
+		obj, exists := objMap[c.relPath]
+		return exists && !c.excluded && !c.notModified && !s.private(c.localRel) && !s.OnlyMissing && !s.sizeOnly() && !cp.isDone(c.relPath, c.info) && !s.manifestUnchanged(c) && !s.trustedUnchanged(c, obj)
+	})
+	if ctx.Err() != nil {
+		s.printSummary(metrics)
+		return fmt.Errorf("sync interrupted: %w", ctx.Err())
+	}
 
-		if info.IsDir() {
-			return nil
+	for i, c := range candidates {
+		relPath, info := c.relPath, c.info
+		if c.excluded {
+			delete(objMap, relPath)
+			continue
+		}
+		if s.private(c.localRel) {
+			s.logger().Errorf("refusing to upload %s: it matches --private, but %v; use a separate zone or pull zone token authentication", relPath, api.ErrNoAccessControl)
+			metrics.fail("upload", relPath, api.ErrNoAccessControl)
+			opsLock.Lock()
+			delete(objMap, relPath)
+			opsLock.Unlock()
+			continue
+		}
+		localFiles = append(localFiles, localFile{relPath: relPath, size: info.Size(), modTime: info.ModTime()})
+		if c.notModified {
+			s.logDebug("Skipping %s: not modified since %s", relPath, s.Since.Format(time.RFC3339))
+			opsLock.Lock()
+			delete(objMap, relPath)
+			opsLock.Unlock()
+			metrics.skip(relPath)
+			continue
 		}
 
-		relPath, _ := filepath.Rel(sourcePath, path)
-		relPath = filepath.ToSlash(relPath)
-		if syncPath != "" {
-			relPath = syncPath + "/" + relPath
+		obj, exists := objMap[relPath]
+
+		if exists && cp.isDone(relPath, info) {
+			s.logDebug("Skipping %s: completed in checkpoint", relPath)
+			opsLock.Lock()
+			delete(objMap, relPath)
+			opsLock.Unlock()
+			metrics.skip(relPath)
+			continue
 		}
 
-		metrics.Lock()
-		metrics.total++
-		metrics.Unlock()
+		if exists && s.manifestUnchanged(c) {
+			s.logDebug("Skipping %s: size and mtime match %s", relPath, ManifestName)
+			opsLock.Lock()
+			delete(objMap, relPath)
+			opsLock.Unlock()
+			metrics.skip(relPath)
+			continue
+		}
 
-		obj, exists := objMap[relPath]
+		if exists && s.trustedUnchanged(c, obj) {
+			s.logDebug("Skipping %s: size, mtime and listed checksum match the last upload", relPath)
+			opsLock.Lock()
+			delete(objMap, relPath)
+			opsLock.Unlock()
+			metrics.skip(relPath)
+			continue
+		}
 
 		if s.OnlyMissing && exists {
 			opsLock.Lock()
 			delete(objMap, relPath)
 			opsLock.Unlock()
-			metrics.Lock()
-			metrics.skipped++
-			metrics.Unlock()
-			return nil
+			metrics.skip(relPath)
+			continue
 		}
 
 		shouldUpload := false
+		reason := "new"
 		var fsChecksum string
 
+		drift := false
 		if !exists {
-			metrics.Lock()
-			metrics.newFile++
-			metrics.Unlock()
+			metrics.newFile.Add(1)
 			shouldUpload = true
+			if s.uploadedUnchanged(relPath, "", info) {
+				drift, reason = true, "removed from the zone, unchanged locally"
+			}
 		} else {
-			if s.SizeOnly {
-				if int64(obj.Length) != info.Size() {
-					metrics.Lock()
-					metrics.modifiedFile++
-					metrics.Unlock()
+			if s.sizeOnly() {
+				size, err := s.storedSize(c.path, info.Size())
+				if err != nil {
+					s.logger().Errorf("reading file %s: %v\n", relPath, err)
+					metrics.fail("read", relPath, err)
+					continue
+				}
+				if int64(obj.Length) != size {
+					metrics.modifiedFile.Add(1)
 					shouldUpload = true
+					reason = "size differs"
 				}
 			} else {
-				_, fsChecksum, err = getFileContent(path)
+				fsChecksum, err = hashes[i].checksum, hashes[i].err
 				if err != nil {
-					log.Printf("ERROR: reading file %s: %v\n", relPath, err)
-					metrics.Lock()
-					metrics.errors++
-					metrics.Unlock()
-					return nil
+					s.logger().Errorf("reading file %s: %v\n", relPath, err)
+					metrics.fail("read", relPath, err)
+					continue
 				}
 				if !strings.EqualFold(fsChecksum, obj.Checksum) {
-					metrics.Lock()
-					metrics.modifiedFile++
-					metrics.Unlock()
+					metrics.modifiedFile.Add(1)
 					shouldUpload = true
+					reason = "checksum differs"
+					if s.uploadedUnchanged(relPath, fsChecksum, info) {
+						drift, reason = true, "remote differs, unchanged locally"
+					}
 				}
 			}
 		}
 
+		if shouldUpload && exists && s.remoteNewer(c, obj) {
+			metrics.modifiedFile.Add(-1)
+			metrics.conflict(relPath, obj.LastChanged.Time, info.ModTime())
+			s.warnf("not overwriting %s: changed in the zone at %s, after the local file (%s)",
+				relPath, obj.LastChanged.Time.Format(time.RFC3339), info.ModTime().UTC().Format(time.RFC3339))
+			opsLock.Lock()
+			delete(objMap, relPath)
+			opsLock.Unlock()
+			continue
+		}
+
+		if shouldUpload && !s.contentTypeAllowed(relPath) {
+			err := fmt.Errorf("content type %q is not allowed", s.API.ContentType(relPath))
+			s.logger().Errorf("refusing to upload %s: %v", relPath, err)
+			metrics.fail("upload", relPath, err)
+			opsLock.Lock()
+			delete(objMap, relPath)
+			opsLock.Unlock()
+			continue
+		}
+
 		if shouldUpload {
 			opsLock.Lock()
 			operations = append(operations, operation{
 				action:   "upload",
-				path:     path,
+				path:     c.path,
 				relPath:  relPath,
 				checksum: fsChecksum,
+				size:     info.Size(),
+				modTime:  info.ModTime(),
 				isNew:    !exists,
+				drift:    drift,
+				reason:   reason,
 			})
 			opsLock.Unlock()
 		} else {
-			metrics.Lock()
-			metrics.skipped++
-			metrics.Unlock()
+			metrics.skip(relPath)
 		}
 
 		opsLock.Lock()
 		delete(objMap, relPath)
 		opsLock.Unlock()
+	}
 
-		return nil
-	})
+	if s.Delete {
+		s.detectRenames(operations, objMap)
+	}
+	plan.uploads = operations
+	plan.localFiles = localFiles
+	plan.Operations = s.planOperations(plan)
+	return nil
+}
 
-	if err != nil {
-		return fmt.Errorf("filesystem walk failed: %w", err)
+// apply runs the operations still listed in plan, then generates, deletes
+// and records what the rest of the run needs.
+func (s *BCDNSyncer) apply(ctx context.Context, plan *SyncPlan) error {
+	if plan.applied {
+		return fmt.Errorf("plan has already been applied")
+	}
+	plan.applied = true
+	sources, syncPath, objMap, remoteCount := plan.sources, plan.syncPath, plan.objMap, plan.remoteCount
+	metrics, cp, localFiles := plan.metrics, plan.cp, plan.localFiles
+	approved := plan.approved()
+	var operations []operation
+	for _, o := range plan.uploads {
+		if approved[o.uploadAction()+" "+o.relPath] {
+			operations = append(operations, o)
+			continue
+		}
+		if o.isNew {
+			metrics.newFile.Add(-1)
+		} else {
+			metrics.modifiedFile.Add(-1)
+		}
+		metrics.skip(o.relPath)
+	}
+
+	s.sortUploads(operations)
+	for _, o := range operations {
+		metrics.planOp(o.report())
 	}
 
 	if len(operations) > 0 {
-		if err := s.processOperationsConcurrently(operations, metrics); err != nil {
+		stopFlush := cp.autoFlush(s.CheckpointInterval, s.logger())
+		var uploaded []operation
+		var err error
+		if s.Atomic && !s.DryRun {
+			uploaded, err = s.syncAtomic(ctx, syncPath, operations, metrics, cp)
+		} else {
+			uploaded, err = s.processOperationsConcurrently(ctx, operations, metrics, cp)
+		}
+		stopFlush()
+		if flushErr := cp.flush(); flushErr != nil {
+			s.logger().Errorf("writing checkpoint: %v", flushErr)
+		}
+		if err != nil {
+			s.printSummary(metrics)
 			return err
 		}
-	}
-
-	if s.Delete && len(objMap) > 0 {
-		deleteOps := []string{}
-		for p, o := range objMap {
-			if !o.IsDirectory {
-				deleteOps = append(deleteOps, p)
+		if ctx.Err() == nil && s.VerifyViaRelist && !s.DryRun {
+			if err := s.verifyViaRelist(ctx, uploaded, metrics); err != nil {
+				s.logger().Errorf("%v", err)
+				metrics.fail("verify", "", err)
 			}
 		}
-		if len(deleteOps) > 0 {
-			metrics.Lock()
-			metrics.deletedFile = len(deleteOps)
-			metrics.Unlock()
-			s.processDeletesConcurrently(deleteOps, metrics)
+	}
+	if ctx.Err() != nil {
+		s.printSummary(metrics)
+		return fmt.Errorf("sync interrupted: %w", ctx.Err())
+	}
+
+	if s.GenerateIndex {
+		s.syncDirectoryIndexes(ctx, syncPath, localFiles, objMap, metrics)
+	}
+	if s.GenerateSitemap {
+		s.syncSitemap(ctx, syncPath, localFiles, objMap, metrics)
+	}
+
+	if s.Delete && len(objMap) > 0 && !s.holdDeletes(metrics) {
+		if err := s.deleteOrphans(ctx, sources, syncPath, objMap, remoteCount, metrics, approved); err != nil {
+			return err
 		}
 	}
+	if s.PruneEmptyDirs {
+		s.pruneEmptyDirs(ctx, syncPath, metrics)
+	}
+
+	s.writeManifest(ctx, syncPath, metrics)
+
+	if s.Purge {
+		s.purgeChanged(ctx, metrics)
+	}
+
+	if s.WriteSyncMarker && metrics.errors.Load() == 0 {
+		s.writeSyncMarker(ctx, syncPath, metrics)
+	}
+
+	if err := cp.finish(metrics.errors.Load() == 0 && metrics.deferred.Load() == 0); err != nil {
+		s.logger().Errorf("removing checkpoint: %v", err)
+	}
 
 	s.printSummary(metrics)
 	return nil
 }
 
-func (s *BCDNSyncer) fetchAllObjectsParallel(rootPrefix string) (map[string]api.BCDNObject, error) {
-	objMap := make(map[string]api.BCDNObject)
-	var mapLock sync.Mutex
-
-	dirQueue := make(chan string, 100000)
-	var wg sync.WaitGroup
-	var errOnce sync.Once
-	var fetchErr error
+// holdDeletes reports whether DeleteAfterVerify keeps the delete phase from
+// running because the uploads before it did not all succeed, so a broken
+// deploy never removes the files the live site still works with.
+func (s *BCDNSyncer) holdDeletes(metrics *syncMetrics) bool {
+	if !s.DeleteAfterVerify {
+		return false
+	}
+	failed := metrics.errors.Load()
+	if s.VerifyViaRelist {
+		failed += metrics.verifyFailed.Load()
+	}
+	if failed == 0 {
+		return false
+	}
+	s.warnf("%d %s before the delete phase, skipping deletes (--delete-after-verify)", failed, plural(int(failed), "failure", "failures"))
+	metrics.Lock()
+	metrics.deletesHeld = true
+	metrics.Unlock()
+	return true
+}
 
-	wg.Add(1)
-	dirQueue <- rootPrefix
+func (s *BCDNSyncer) deleteReason(p string) string {
+	if to, ok := s.renames[p]; ok {
+		return "renamed to " + to
+	}
+	return "not in source"
+}
 
-	for i := 0; i < s.Concurrency; i++ {
-		go func() {
-			for path := range dirQueue {
-				objects, err := s.API.List(path)
-				if err != nil {
-					errOnce.Do(func() { fetchErr = err })
-					wg.Done()
-					continue
-				}
+// deleteOrphans removes the remote objects still left in objMap once every
+// local file has been accounted for. With approved set, only the deletes it
+// lists are run.
+func (s *BCDNSyncer) deleteOrphans(ctx context.Context, sources []string, syncPath string, objMap map[string]api.BCDNObject, remoteCount int, metrics *syncMetrics, approved map[string]bool) error {
+	deleteOps := []string{}
+	sizes := map[string]int64{}
+	for _, o := range objMap {
+		p := objectPath(s.API.ZoneName, o)
+		rel := p
+		if syncPath != "" {
+			rel = strings.TrimPrefix(p, syncPath+"/")
+		}
+		if !o.IsDirectory && s.deletable(rel) && (approved == nil || approved["delete "+p]) {
+			deleteOps = append(deleteOps, p)
+			sizes[p] = int64(o.Length)
+		}
+	}
+	s.sortPaths(deleteOps)
+	deleteOps, err := s.approveDeletes(deleteOps, remoteCount, int(metrics.total.Load()), "remote")
+	if err != nil {
+		s.printSummary(metrics)
+		return err
+	}
+	if len(deleteOps) > 0 {
+		metrics.deletedFile.Add(int64(len(deleteOps)))
+		for _, p := range deleteOps {
+			metrics.plan("delete", p, sizes[p], s.deleteReason(p))
+		}
 
-				for _, obj := range objects {
-					fullPath := obj.Path
-					if !strings.HasSuffix(fullPath, "/") && fullPath != "" {
-						fullPath += "/"
-					}
-					fullPath += obj.ObjectName
-					
-					objPath := strings.TrimPrefix(fullPath, "/"+s.API.ZoneName+"/")
-					objPath = strings.TrimPrefix(objPath, s.API.ZoneName+"/")
-					objPath = strings.TrimPrefix(objPath, "/")
-					objPath = filepath.ToSlash(filepath.Clean(objPath))
-
-					if obj.IsDirectory {
-						wg.Add(1)
-						go func(p string) {
-							dirQueue <- p
-						}(objPath)
-					} else {
-						mapLock.Lock()
-						objMap[objPath] = obj
-						mapLock.Unlock()
-					}
-				}
-				wg.Done()
+		var deleteCp *deleteCheckpoint
+		if s.DeleteCheckpoint != "" && !s.DryRun {
+			fingerprint, err := localFingerprint(sources, syncPath)
+			if err != nil {
+				return fmt.Errorf("failed to fingerprint local tree: %w", err)
+			}
+			deleteCp, err = createDeleteCheckpoint(s.DeleteCheckpoint, deleteCheckpointHeader{
+				Fingerprint: fingerprint,
+				SyncPath:    syncPath,
+				Pending:     deleteOps,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to write delete checkpoint: %w", err)
 			}
-		}()
+		}
+		// Only failed deletes keep the journal; a failed upload earlier in
+		// the run has nothing to resume.
+		errorsBefore := metrics.errors.Load()
+		groups, files := s.collapseDeletes(syncPath, deleteOps)
+		files = append(files, s.processDirectoryDeletes(ctx, groups, metrics, deleteCp)...)
+		err := s.processDeletesConcurrently(ctx, files, metrics, deleteCp)
+		deleteCp.finish(metrics.errors.Load() == errorsBefore && ctx.Err() == nil)
+		if err != nil {
+			s.printSummary(metrics)
+			return err
+		}
+		if ctx.Err() != nil {
+			s.printSummary(metrics)
+			return fmt.Errorf("sync interrupted: %w", ctx.Err())
+		}
 	}
+	return nil
+}
 
-	waitDone := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(dirQueue)
-		close(waitDone)
-	}()
+func (s *BCDNSyncer) fetchAllObjectsParallel(ctx context.Context, rootPrefix string) (map[string]api.BCDNObject, error) {
+	objMap, _, err := s.fetchObjectsAndDirs(ctx, rootPrefix)
+	return objMap, err
+}
 
-	select {
-	case <-waitDone:
-	case <-time.After(15 * time.Minute):
-		return nil, fmt.Errorf("listing timeout: possible network issue or massive directory structure")
-	}
+// fetchObjectsAndDirs lists rootPrefix recursively and also returns every
+// directory seen below it, including empty ones.
+func (s *BCDNSyncer) fetchObjectsAndDirs(ctx context.Context, rootPrefix string) (map[string]api.BCDNObject, []string, error) {
+	objMap := make(map[string]api.BCDNObject)
+	var dirs []string
+	var mapLock sync.Mutex
 
-	return objMap, fetchErr
+	listCtx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+	defer cancel()
+	err := s.API.ListTreeContext(listCtx, rootPrefix, s.workers(s.ListConcurrency), func(obj api.BCDNObject) error {
+		mapLock.Lock()
+		defer mapLock.Unlock()
+		if obj.IsDirectory {
+			dirs = append(dirs, objectPath(s.API.ZoneName, obj))
+		} else {
+			objMap[s.keys().ObjectKey(s.API.ZoneName, obj)] = obj
+		}
+		return nil
+	})
+	if err != nil && listCtx.Err() != nil && ctx.Err() == nil {
+		return nil, nil, fmt.Errorf("listing timeout: possible network issue or massive directory structure")
+	}
+	return objMap, dirs, err
 }
 
-func (s *BCDNSyncer) processOperationsConcurrently(operations []operation, metrics *syncMetrics) error {
-	sem := make(chan struct{}, s.Concurrency)
+func (s *BCDNSyncer) processOperationsConcurrently(ctx context.Context, operations []operation, metrics *syncMetrics, cp *checkpoint) ([]operation, error) {
+	ctx, stop := newFatalStop(ctx)
+	defer stop.cancel()
+	var uploaded []operation
+	var uploadedLock sync.Mutex
+
+	progress := newProgressTracker(operations)
+	if s.ProgressInterval > 0 {
+		stop := progress.report(s.ProgressInterval, s.logger())
+		defer stop()
+	}
+
+	sem := make(chan struct{}, s.workers(s.UploadConcurrency))
 	var wg sync.WaitGroup
 	for _, op := range operations {
 		wg.Add(1)
+		if s.Sorted {
+			sem <- struct{}{}
+		}
 		go func(o operation) {
 			defer wg.Done()
-			sem <- struct{}{}
+			if !s.Sorted {
+				sem <- struct{}{}
+			}
 			defer func() { <-sem }()
+			defer progress.complete(o.size)
+			if ctx.Err() != nil {
+				return
+			}
 
-			content, checksum, err := getFileContent(o.path)
-			if err != nil {
-				metrics.Lock()
-				metrics.errors++
-				metrics.Unlock()
+			if !s.reserveTransfer(o, metrics) {
 				return
 			}
+			s.fileStart(metrics, o.relPath, o.size)
+
+			checksum := o.checksum
+			if checksum == "" && s.ChecksumAlgo != ChecksumNone {
+				var err error
+				checksum, err = s.fileChecksum(o.path, o.size, o.modTime)
+				if err != nil {
+					s.logger().Errorf("reading file %s: %v", o.relPath, err)
+					metrics.fail("read", o.relPath, err)
+					metrics.transferred.Add(-o.size)
+					s.fileComplete(metrics, o.relPath, err)
+					return
+				}
+			}
 
 			if !s.DryRun {
-				if err := s.API.Upload(o.relPath, content, checksum); err != nil {
-					log.Printf("ERROR: upload failed for %s: %v", o.relPath, err)
-					metrics.Lock()
-					metrics.errors++
-					metrics.Unlock()
+				if err := s.breaker.acquire(ctx); err != nil {
+					metrics.transferred.Add(-o.size)
+					s.fileComplete(metrics, o.relPath, err)
+					return
 				}
+				ctx, done := s.inFlight(ctx)
+				defer done()
+				stored, err := s.uploadFile(ctx, o, checksum)
+				if errors.Is(err, api.ErrChecksumMismatch) {
+					// The file may have changed while it was read; hash it
+					// again and give the upload one more chance.
+					s.logger().Infof("Checksum rejected for %s, retrying", o.relPath)
+					if checksum, err = s.storedChecksum(o.path); err == nil {
+						stored, err = s.uploadFile(ctx, o, checksum)
+					}
+				}
+				if err == nil && s.VerifyUploads {
+					err = s.verifyUpload(ctx, o.relPath, checksum, stored)
+					if errors.Is(err, errVerifyMismatch) {
+						s.logger().Infof("VERIFY FAILED: %s: %v, uploading again", o.relPath, err)
+						metrics.verifyFailed.Add(1)
+						if stored, err = s.uploadFile(ctx, o, checksum); err == nil {
+							err = s.verifyUpload(ctx, o.relPath, checksum, stored)
+						}
+					}
+				}
+				s.breaker.finish(ctx, err)
+				if err != nil {
+					stop.check(err)
+					op := "upload"
+					if errors.Is(err, errVerifyMismatch) {
+						op = "verify"
+					}
+					s.logger().Errorf("upload failed for %s: %v", o.relPath, err)
+					metrics.fail(op, o.relPath, err)
+					metrics.transferred.Add(-o.size)
+					s.fileComplete(metrics, o.relPath, err)
+					return
+				}
+				o.checksum = checksum
+				cp.done(o)
+				metrics.Lock()
+				metrics.changed = append(metrics.changed, o.relPath)
+				metrics.bytesUploaded.Add(stored)
+				metrics.Unlock()
+				s.manifest.put(o.relPath, stored, checksum, o.modTime)
+				uploadedLock.Lock()
+				uploaded = append(uploaded, o)
+				uploadedLock.Unlock()
 			} else {
-				log.Printf("DRY-RUN: Would upload %s", o.relPath)
+				s.logger().Infof("DRY-RUN: Would upload %s", o.relPath)
 			}
+			metrics.done(o.uploadAction(), o.relPath)
+			s.fileComplete(metrics, o.relPath, nil)
 		}(op)
 	}
 	wg.Wait()
-	return nil
+	return uploaded, stop.err
 }
 
-func (s *BCDNSyncer) processDeletesConcurrently(deleteOps []string, metrics *syncMetrics) {
-	sem := make(chan struct{}, s.Concurrency)
+// reserveTransfer counts o against the per-run transfer budget before it is
+// started. Once the budget is used up no new uploads begin; uploads already
+// in flight are allowed to finish.
+func (s *BCDNSyncer) reserveTransfer(o operation, metrics *syncMetrics) bool {
+	for {
+		transferred := metrics.transferred.Load()
+		if s.TransferBudget > 0 && transferred >= s.TransferBudget {
+			metrics.deferred.Add(1)
+			s.logDebug("Transfer budget reached, deferring %s", o.relPath)
+			return false
+		}
+		if metrics.transferred.CompareAndSwap(transferred, transferred+o.size) {
+			return true
+		}
+	}
+}
+
+func (s *BCDNSyncer) processDeletesConcurrently(ctx context.Context, deleteOps []string, metrics *syncMetrics, cp *deleteCheckpoint) error {
+	ctx, stop := newFatalStop(ctx)
+	defer stop.cancel()
+	sem := make(chan struct{}, s.workers(s.DeleteConcurrency))
 	var wg sync.WaitGroup
 	for _, path := range deleteOps {
 		wg.Add(1)
+		if s.Sorted {
+			sem <- struct{}{}
+		}
 		go func(p string) {
 			defer wg.Done()
-			sem <- struct{}{}
+			if !s.Sorted {
+				sem <- struct{}{}
+			}
 			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
 
+			s.fileStart(metrics, p, 0)
 			if !s.DryRun {
-				log.Printf("Deleting %s", p)
-				s.API.Delete(p)
+				if err := s.breaker.acquire(ctx); err != nil {
+					s.fileComplete(metrics, p, err)
+					return
+				}
+				s.logger().Infof("Deleting %s", p)
+				ctx, done := s.inFlight(ctx)
+				defer done()
+				err := s.API.DeleteContext(ctx, p)
+				if errors.Is(err, api.ErrNotFound) {
+					s.logDebug("%s is already gone", p)
+					err = nil
+				}
+				s.breaker.finish(ctx, err)
+				if err != nil {
+					stop.check(err)
+					s.logger().Errorf("delete failed for %s: %v", p, err)
+					metrics.fail("delete", p, err)
+					s.fileComplete(metrics, p, err)
+					return
+				}
+				cp.markDeleted(p)
+				s.manifest.remove(p)
+				metrics.Lock()
+				metrics.changed = append(metrics.changed, p)
+				metrics.bytesDeleted.Add(metrics.deleteSizes[p])
+				metrics.Unlock()
 			} else {
-				log.Printf("DRY-RUN: Would delete %s", p)
+				s.logger().Infof("DRY-RUN: Would delete %s", p)
 			}
+			metrics.done("delete", p)
+			s.fileComplete(metrics, p, nil)
 		}(path)
 	}
 	wg.Wait()
+	return stop.err
 }
 
 func (s *BCDNSyncer) printSummary(m *syncMetrics) {
-	log.Printf("=== Sync Summary ===")
-	log.Printf("Total: %d, New: %d, Updated: %d, Deleted: %d, Errors: %d", 
-		m.total, m.newFile, m.modifiedFile, m.deletedFile, m.errors)
+	if s.Progress != nil {
+		s.Progress.OnSummary(m.summary())
+	}
+	if s.JSONOutput != nil {
+		s.writeReport(m)
+		return
+	}
+	if s.DryRun {
+		s.printPlan(m)
+	}
+	log := s.summaryLogger()
+	log.Infof("=== Sync Summary ===")
+	sum := m.summary()
+	log.Infof("Total: %d, New: %d, Updated: %d, Deleted: %d, Errors: %d",
+		sum.Total, sum.New, sum.Updated, sum.Deleted, sum.Errors)
+	rate := float64(sum.BytesUploaded+sum.BytesDownloaded) / sum.Elapsed.Seconds()
+	if s.Direction == DirectionPull {
+		log.Infof("Downloaded: %s, Deleted: %s, Elapsed: %s (%s/s)", formatBytes(sum.BytesDownloaded), formatBytes(sum.BytesDeleted), sum.Elapsed.Round(time.Millisecond), formatBytes(int64(rate)))
+	} else {
+		log.Infof("Uploaded: %s, Deleted: %s, Elapsed: %s (%s/s)", formatBytes(sum.BytesUploaded), formatBytes(sum.BytesDeleted), sum.Elapsed.Round(time.Millisecond), formatBytes(int64(rate)))
+	}
+	if s.VerifyViaRelist || s.VerifyUploads {
+		log.Infof("Verification failures: %d", sum.VerifyFailed)
+	}
+	if sum.Conflicts > 0 {
+		log.Infof("Conflicts: %d %s changed in the zone after the local copy, not overwritten:", sum.Conflicts, plural(sum.Conflicts, "file", "files"))
+		for _, c := range m.conflictList() {
+			log.Infof("  %s (zone %s, local %s)", c.Path, c.RemoteChanged.Format(time.RFC3339), c.LocalModified.Format(time.RFC3339))
+		}
+	}
+	if sum.DeletesHeld {
+		log.Infof("Deletes skipped: not every upload succeeded (--delete-after-verify)")
+	}
+	if s.TransferBudget > 0 {
+		log.Infof("Transferred: %s of %s budget, Deferred: %d", formatBytes(sum.Transferred), formatBytes(s.TransferBudget), sum.Deferred)
+	}
+}
+
+func (s *BCDNSyncer) private(localRel string) bool {
+	return matchAny(s.Private, localRel, false)
+}
+
+func (s *BCDNSyncer) contentTypeAllowed(relPath string) bool {
+	if len(s.AllowedContentTypes) == 0 {
+		return true
+	}
+	contentType := s.API.ContentType(relPath)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for _, pattern := range s.AllowedContentTypes {
+		if ok, _ := path.Match(strings.ToLower(pattern), contentType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *BCDNSyncer) logger() api.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	if s.API.Logger != nil {
+		return s.API.Logger
+	}
+	return api.StdLogger{}
+}
+
+// workers returns n, or Concurrency when n is not set.
+func (s *BCDNSyncer) workers(n int) int {
+	if n > 0 {
+		return n
+	}
+	return s.Concurrency
+}
+
+func (s *BCDNSyncer) warnf(format string, args ...interface{}) {
+	api.Warnf(s.logger(), format, args...)
+}
+
+// summaryLogger is the logger without any level filter, so the summary is
+// printed even in quiet mode.
+func (s *BCDNSyncer) summaryLogger() api.Logger {
+	if l, ok := s.logger().(api.LevelLogger); ok {
+		return l.Logger
+	}
+	return s.logger()
 }
 
 func (s *BCDNSyncer) logDebug(format string, args ...interface{}) {
 	if s.Verbose {
-		log.Printf("DEBUG: "+format, args...)
+		s.logger().Debugf(format, args...)
 	}
 }
 
-func getFileContent(path string) ([]byte, string, error) {
-	fileContent, err := os.ReadFile(path)
+func getFileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readerChecksum(f)
+}
+
+func readerChecksum(f io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// uploadFile streams the file from disk so memory use does not grow with
+// file size or concurrency; only files Compress applies to are read into
+// memory. It returns the number of bytes stored.
+func (s *BCDNSyncer) uploadFile(ctx context.Context, o operation, checksum string) (int64, error) {
+	if s.compressible(o.path) {
+		data, err := s.compressFile(o.path)
+		if err != nil {
+			return 0, err
+		}
+		if data != nil {
+			return int64(len(data)), s.API.UploadEncodedContext(ctx, o.relPath, data, checksum, "gzip")
+		}
+	}
+	f, err := s.openLocal(o.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
 	if err != nil {
-		return nil, "", err
+		return 0, err
 	}
-	checksum := sha256.Sum256(fileContent)
-	return fileContent, fmt.Sprintf("%x", checksum), nil
+	return info.Size(), s.API.UploadStreamContext(ctx, o.relPath, f, info.Size(), checksum)
 }
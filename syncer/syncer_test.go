@@ -0,0 +1,152 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+func TestSyncSeveralSources(t *testing.T) {
+	tests := []struct {
+		name        string
+		sources     []map[string]string
+		wantErr     bool
+		wantUpload  []string
+		wantDeleted []string
+	}{
+		{
+			name:        "disjoint sources",
+			sources:     []map[string]string{{"a.txt": "a", "keep.txt": "kept"}, {"sub/b.txt": "b"}},
+			wantUpload:  []string{"site/a.txt", "site/sub/b.txt"},
+			wantDeleted: []string{"site/stale.txt"},
+		},
+		{
+			name:    "colliding sources",
+			sources: []map[string]string{{"a.txt": "a"}, {"a.txt": "other a", "keep.txt": "kept"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var uploaded, deleted []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				name := strings.TrimPrefix(r.URL.Path, "/zone/")
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.Method {
+				case http.MethodGet:
+					if name != "site/" {
+						fmt.Fprint(w, `[]`)
+						return
+					}
+					fmt.Fprintf(w, `[
+						{"Path": "/zone/site/", "ObjectName": "keep.txt", "Length": 4, "Checksum": "%X"},
+						{"Path": "/zone/site/", "ObjectName": "stale.txt", "Length": 5}
+					]`, sha256.Sum256([]byte("kept")))
+				case http.MethodPut:
+					uploaded = append(uploaded, name)
+					w.WriteHeader(http.StatusCreated)
+				case http.MethodDelete:
+					deleted = append(deleted, name)
+				}
+			}))
+			defer srv.Close()
+
+			var sources []string
+			for _, files := range tt.sources {
+				dir := t.TempDir()
+				for name, content := range files {
+					os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+					if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				sources = append(sources, dir)
+			}
+			s := BCDNSyncer{
+				API:              api.BCDNStorage{ZoneName: "zone", APIKey: "key", Endpoint: srv.URL},
+				Delete:           true,
+				MaxDeletePercent: 100,
+				Logger:           discardLogger{},
+			}
+			_, err := s.Run(t.Context(), sources, "site")
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			sort.Strings(uploaded)
+			sort.Strings(deleted)
+			if strings.Join(uploaded, ",") != strings.Join(tt.wantUpload, ",") {
+				t.Errorf("uploaded %v, want %v", uploaded, tt.wantUpload)
+			}
+			if strings.Join(deleted, ",") != strings.Join(tt.wantDeleted, ",") {
+				t.Errorf("deleted %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+// Run with -race: workers update the counters and path lists while the
+// summary is read.
+func TestSyncMetricsConcurrent(t *testing.T) {
+	const workers, perWorker = 32, 500
+	m := &syncMetrics{}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				m.summary()
+				m.MarshalJSON()
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				p := fmt.Sprintf("w%d/f%d", w, i)
+				m.total.Add(1)
+				m.newFile.Add(1)
+				m.bytesUploaded.Add(10)
+				m.done("upload", p)
+				if i%10 == 0 {
+					m.skip(p)
+				}
+				if i%50 == 0 {
+					m.fail("upload", p, errors.New("failed"))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+
+	sum := m.summary()
+	if want := workers * perWorker; sum.Total != want || sum.New != want || len(m.result().Uploaded) != want {
+		t.Errorf("total %d, new %d, uploaded %d, want %d each", sum.Total, sum.New, len(m.result().Uploaded), want)
+	}
+	if want := int64(workers * perWorker * 10); sum.BytesUploaded != want {
+		t.Errorf("bytes uploaded %d, want %d", sum.BytesUploaded, want)
+	}
+	if want := workers * perWorker / 10; sum.Skipped != want || len(m.result().Skipped) != want {
+		t.Errorf("skipped %d (%d paths), want %d", sum.Skipped, len(m.result().Skipped), want)
+	}
+	if want := workers * perWorker / 50; sum.Errors != want || len(m.result().Errors) != want {
+		t.Errorf("errors %d (%d recorded), want %d", sum.Errors, len(m.result().Errors), want)
+	}
+}
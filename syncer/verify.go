@@ -0,0 +1,69 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/veter2005/bunny-storage-sync/api"
+)
+
+var errVerifyMismatch = errors.New("stored object does not match the upload")
+
+// verifyUpload reads back the metadata of a single upload and compares it
+// with the checksum and size that were sent.
+func (s *BCDNSyncer) verifyUpload(ctx context.Context, relPath, checksum string, size int64) error {
+	obj, err := s.API.StatContext(ctx, relPath)
+	if err != nil {
+		return fmt.Errorf("verifying upload: %w", err)
+	}
+	if obj.Checksum != "" && !strings.EqualFold(obj.Checksum, checksum) {
+		return fmt.Errorf("%w (checksum %s, want %s)", errVerifyMismatch, obj.Checksum, checksum)
+	}
+	if int64(obj.Length) != size {
+		return fmt.Errorf("%w (%d bytes stored, %d sent)", errVerifyMismatch, obj.Length, size)
+	}
+	return nil
+}
+
+// verifyViaRelist re-lists the deepest directory containing every uploaded
+// file once and checks each upload against the fresh listing, instead of
+// issuing one request per file.
+func (s *BCDNSyncer) verifyViaRelist(ctx context.Context, uploaded []operation, metrics *syncMetrics) error {
+	if len(uploaded) == 0 {
+		return nil
+	}
+	prefix := remoteDir(uploaded[0].relPath)
+	for _, o := range uploaded[1:] {
+		for prefix != "" && !strings.HasPrefix(o.relPath, prefix+"/") {
+			prefix = remoteDir(prefix)
+		}
+	}
+
+	s.logger().Infof("Verifying %d uploads by re-listing %q", len(uploaded), prefix)
+	objMap, err := s.fetchAllObjectsParallel(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("verification listing failed: %w", err)
+	}
+	remote := make(map[string]api.BCDNObject, len(objMap))
+	for _, obj := range objMap {
+		remote[objectPath(s.API.ZoneName, obj)] = obj
+	}
+
+	for _, o := range uploaded {
+		obj, ok := remote[o.relPath]
+		switch {
+		case !ok:
+			s.logger().Infof("VERIFY FAILED: %s is missing after upload", o.relPath)
+		case obj.Checksum != "" && !strings.EqualFold(obj.Checksum, o.checksum):
+			s.logger().Infof("VERIFY FAILED: %s checksum mismatch (local %s, remote %s)", o.relPath, o.checksum, obj.Checksum)
+		case int64(obj.Length) != o.size:
+			s.logger().Infof("VERIFY FAILED: %s size mismatch (local %d, remote %d)", o.relPath, o.size, obj.Length)
+		default:
+			continue
+		}
+		metrics.verifyFailed.Add(1)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DuplicateError    = "error"
+	DuplicateLastWins = "last-wins"
+)
+
+type candidate struct {
+	path     string
+	localRel string
+	relPath  string
+	info     os.FileInfo
+	excluded bool
+	// notModified marks files older than Since; they are neither read nor
+	// uploaded, but still count as present locally.
+	notModified bool
+}
+
+// collectLocalFiles walks the sources in order and returns one candidate per
+// remote path. Two local files resolving to the same remote path, within one
+// source or across sources, are handled according to OnDuplicate, so each
+// remote path is uploaded at most once.
+func (s *BCDNSyncer) collectLocalFiles(sources []string, syncPath string, metrics *syncMetrics) ([]candidate, error) {
+	var candidates []candidate
+	byRemote := map[string]int{}
+	s.renamed = map[string]string{}
+	s.localDirs = map[string]bool{}
+
+	// With several sources the relative paths of colliding files are often
+	// identical, so collisions are reported with the full local paths.
+	name := func(c candidate) string {
+		if len(sources) > 1 {
+			return c.path
+		}
+		return c.localRel
+	}
+
+	for _, sourcePath := range sources {
+		if err := s.walkSource(sourcePath, syncPath, metrics, func(c candidate) error {
+			for dir := remoteDir(c.relPath); dir != "" && !s.localDirs[dir]; dir = remoteDir(dir) {
+				s.localDirs[dir] = true
+			}
+			if i, dup := byRemote[c.relPath]; dup {
+				other := candidates[i]
+				if s.OnDuplicate != DuplicateLastWins {
+					return fmt.Errorf("%s and %s both map to remote path %s", name(other), name(c), c.relPath)
+				}
+				s.warnf("%s and %s both map to remote path %s, using %s", name(other), name(c), c.relPath, name(c))
+				candidates[i] = c
+				return nil
+			}
+			byRemote[c.relPath] = len(candidates)
+			candidates = append(candidates, c)
+			return nil
+		}); err != nil {
+			return candidates, err
+		}
+	}
+	return candidates, nil
+}
+
+func (s *BCDNSyncer) walkSource(sourcePath, syncPath string, metrics *syncMetrics, add func(candidate) error) error {
+	if s.sourceFS != nil {
+		return s.walkFS(syncPath, metrics, add)
+	}
+	return s.walkTree(sourcePath, sourcePath, "", syncPath, metrics, add)
+}
+
+// walkTree walks root and names its files relative to the source as
+// prefix/..., so the contents of a followed directory symlink appear under
+// the link's own path.
+func (s *BCDNSyncer) walkTree(sourcePath, root, prefix, syncPath string, metrics *syncMetrics, add func(candidate) error) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			s.logger().Errorf("accessing path %q: %v\n", path, err)
+			metrics.fail("walk", path, err)
+			return nil
+		}
+
+		if path != root && s.skipName(info.Name()) {
+			s.logDebug("Skipping junk or hidden file %s", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(root, path)
+		localRel := filepath.ToSlash(filepath.Join(prefix, relPath))
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !s.FollowSymlinks {
+				s.logger().Infof("Skipping symlink %s (use --follow-symlinks to sync its target)", localRel)
+				return nil
+			}
+			target, err := os.Stat(path)
+			if err != nil {
+				s.logger().Errorf("following symlink %s: %v", localRel, err)
+				metrics.fail("walk", localRel, err)
+				return nil
+			}
+			if target.IsDir() {
+				if s.excludedDir(localRel) || s.outsidePrefix(localRel) {
+					s.logDebug("Skipping excluded directory %s", localRel)
+					return nil
+				}
+				if symlinkLoop(sourcePath, localRel, target) {
+					s.warnf("skipping symlink %s, it points to one of its own parent directories", localRel)
+					return nil
+				}
+				resolved, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					s.logger().Errorf("following symlink %s: %v", localRel, err)
+					metrics.fail("walk", localRel, err)
+					return nil
+				}
+				s.logDebug("Following symlink %s to %s", localRel, resolved)
+				return s.walkTree(sourcePath, resolved, localRel, syncPath, metrics, add)
+			}
+			info = target
+		}
+		if info.IsDir() {
+			if path != root && (s.excludedDir(localRel) || s.outsidePrefix(localRel)) {
+				s.logDebug("Skipping excluded directory %s", localRel)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return s.addFile(path, localRel, info, syncPath, metrics, add)
+	})
+}
+
+// addFile turns a walked file into a candidate, applying the filters,
+// name checks and limits every source shares, and passes it to add.
+func (s *BCDNSyncer) addFile(path, localRel string, info os.FileInfo, syncPath string, metrics *syncMetrics, add func(candidate) error) error {
+	if !s.underPrefix(localRel) {
+		s.logDebug("Skipping %s: outside the strip prefix %s", localRel, s.prefix)
+		return nil
+	}
+	if !s.selected(localRel) {
+		s.logDebug("Skipping %s: filtered by --ext, --include/--exclude or %s", localRel, ignoreFileName)
+		return nil
+	}
+	c := candidate{path: path, localRel: localRel, relPath: s.remotePath(syncPath, localRel), info: info}
+	if problem := nameProblem(localRel); problem != "" && s.PathMap[localRel] == "" {
+		switch s.SanitizeNames {
+		case SanitizeError:
+			s.logger().Errorf("%s: %s", localRel, problem)
+			metrics.fail("walk", localRel, errors.New(problem))
+			c.excluded = true
+		case SanitizeSkip:
+			s.logDebug("Skipping %s: %s", localRel, problem)
+			metrics.skip(localRel)
+			c.excluded = true
+		case SanitizeRewrite:
+			c.relPath = s.remotePath(syncPath, sanitizeName(localRel))
+			s.logDebug("Renaming %s to %s: %s", localRel, c.relPath, problem)
+			s.renamed[c.relPath] = localRel
+		default:
+			s.warnf("%s: %s", localRel, problem)
+		}
+	}
+	if !c.excluded && s.tooLarge(localRel, info.Size()) {
+		metrics.skip(localRel)
+		c.excluded = true
+	}
+	if !s.Since.IsZero() && info.ModTime().Before(s.Since) {
+		c.notModified = true
+	}
+	if s.WriteSyncMarker && c.relPath == syncMarkerName {
+		s.warnf("skipping local %s, the path is reserved for the sync marker", localRel)
+		return nil
+	}
+	if (s.UseManifest || s.TrustCache) && c.relPath == manifestPath(syncPath) {
+		s.warnf("skipping local %s, the path is reserved for the zone manifest", localRel)
+		return nil
+	}
+
+	total := int(metrics.total.Add(1))
+	if s.MaxObjects > 0 && total > s.MaxObjects {
+		return fmt.Errorf("source contains more than %d files (reached %d); raise --max-objects if this is intended", s.MaxObjects, total)
+	}
+
+	return add(c)
+}
+
+// tooLarge reports, with a warning, whether a file exceeds MaxFileSize. Such
+// files are neither uploaded nor is their remote copy deleted.
+func (s *BCDNSyncer) tooLarge(localRel string, size int64) bool {
+	if s.MaxFileSize <= 0 || size <= s.MaxFileSize {
+		return false
+	}
+	s.warnf("skipping %s, its size %s exceeds --max-file-size %s", localRel, formatBytes(size), formatBytes(s.MaxFileSize))
+	return true
+}
+
+// symlinkLoop reports whether target, the directory a symlink at localRel
+// resolves to, is the source root or one of the link's parent directories as
+// they are reached through the walk. Following such a link would recurse
+// forever; comparing the directories with os.SameFile catches loops through
+// any number of links.
+func symlinkLoop(sourcePath, localRel string, target os.FileInfo) bool {
+	dir := sourcePath
+	for _, part := range strings.Split(localRel, "/") {
+		if info, err := os.Stat(dir); err == nil && os.SameFile(info, target) {
+			return true
+		}
+		dir = filepath.Join(dir, part)
+	}
+	return false
+}
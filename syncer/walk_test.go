@@ -0,0 +1,103 @@
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCollectLocalFilesDuplicates(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	for dir, content := range map[string]string{first: "first", second: "second"} {
+		if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, content+".txt"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+		want    string
+	}{
+		{"default", "", true, ""},
+		{"error", DuplicateError, true, ""},
+		{"last wins", DuplicateLastWins, false, filepath.Join(second, "page.html")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := BCDNSyncer{OnDuplicate: tt.policy, Logger: discardLogger{}}
+			candidates, err := s.collectLocalFiles([]string{first, second}, "site", &syncMetrics{})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "site/page.html") {
+					t.Fatalf("got %v, want an error naming site/page.html", err)
+				}
+				if !strings.Contains(err.Error(), first) || !strings.Contains(err.Error(), second) {
+					t.Errorf("error %q does not name both local files", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			byRemote := map[string]string{}
+			for _, c := range candidates {
+				if _, dup := byRemote[c.relPath]; dup {
+					t.Errorf("remote path %s collected twice", c.relPath)
+				}
+				byRemote[c.relPath] = c.path
+			}
+			if byRemote["site/page.html"] != tt.want {
+				t.Errorf("site/page.html comes from %q, want %q", byRemote["site/page.html"], tt.want)
+			}
+			if len(byRemote) != 3 {
+				t.Errorf("collected %v, want page.html, first.txt and second.txt", byRemote)
+			}
+		})
+	}
+}
+
+func TestFollowSymlinksLoops(t *testing.T) {
+	dir := t.TempDir()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(os.MkdirAll(filepath.Join(dir, "a", "b"), 0755))
+	must(os.WriteFile(filepath.Join(dir, "a", "b", "page.html"), []byte("page"), 0644))
+	must(os.WriteFile(filepath.Join(dir, "target.txt"), []byte("target"), 0644))
+	must(os.Mkdir(filepath.Join(dir, "other"), 0755))
+	must(os.WriteFile(filepath.Join(dir, "other", "o.txt"), []byte("other"), 0644))
+	must(os.Symlink(".", filepath.Join(dir, "a", "b", "here")))
+	must(os.Symlink(filepath.Join("..", ".."), filepath.Join(dir, "a", "b", "up")))
+	must(os.Symlink("self", filepath.Join(dir, "self")))
+	must(os.Symlink("target.txt", filepath.Join(dir, "link.txt")))
+	must(os.Symlink("other", filepath.Join(dir, "other-link")))
+
+	s := BCDNSyncer{FollowSymlinks: true, Logger: discardLogger{}}
+	metrics := &syncMetrics{}
+	candidates, err := s.collectLocalFiles([]string{dir}, "", metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range candidates {
+		got = append(got, c.relPath)
+	}
+	sort.Strings(got)
+	want := []string{"a/b/page.html", "link.txt", "other-link/o.txt", "other/o.txt", "target.txt"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("collected %v, want %v", got, want)
+	}
+	// The link to itself cannot be resolved and is reported, not followed.
+	if res := metrics.result(); len(res.Errors) != 1 || res.Errors[0].Path != "self" {
+		t.Errorf("errors %v, want one for self", res.Errors)
+	}
+}